@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// buffered audit log of the commands run, nil when auditing is disabled
+var (
+	auditFile   *os.File
+	auditWriter *bufio.Writer
+	auditMutex  sync.Mutex
+)
+
+// opens the audit log configured with auditlog and flushes it periodically
+func openAuditLog() {
+	if !viper.IsSet("auditlog") {
+		return
+	}
+
+	file, err := os.OpenFile(viper.GetString("auditlog"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		logger.Error("Cannot open audit log", "file", viper.GetString("auditlog"), "error", err)
+		return
+	}
+
+	auditMutex.Lock()
+	auditFile = file
+	auditWriter = bufio.NewWriter(file)
+	auditMutex.Unlock()
+
+	go func() {
+		for range time.Tick(5 * time.Second) {
+			flushAuditLog()
+		}
+	}()
+}
+
+// records a command run in the audit log
+func auditCommand(m *discordgo.MessageCreate, request commandRequest, status string) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	if auditWriter == nil {
+		return
+	}
+
+	fmt.Fprintf(auditWriter, "%s\tguild:%s\tchannel:%s\tuser:%s\tusername:%s\tcommand:%s\tstatus:%s\n",
+		time.Now().Format(time.RFC3339), request.GuildID, m.ChannelID, m.Author.ID, m.Author.Username,
+		strings.ReplaceAll(request.Command, "\t", " "), status)
+}
+
+// writes any buffered audit log entries to disk
+func flushAuditLog() {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	if auditWriter == nil {
+		return
+	}
+
+	if err := auditWriter.Flush(); err != nil {
+		logger.Error("Cannot write audit log", "error", err)
+	}
+	auditFile.Sync()
+}
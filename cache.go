@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// gateway intents that can be listed under intents
+var intentNames = map[string]discordgo.Intent{
+	"guilds":                 discordgo.IntentsGuilds,
+	"guildmembers":           discordgo.IntentsGuildMembers,
+	"guildbans":              discordgo.IntentsGuildBans,
+	"guildemojis":            discordgo.IntentsGuildEmojis,
+	"guildintegrations":      discordgo.IntentsGuildIntegrations,
+	"guildwebhooks":          discordgo.IntentsGuildWebhooks,
+	"guildinvites":           discordgo.IntentsGuildInvites,
+	"guildvoicestates":       discordgo.IntentsGuildVoiceStates,
+	"guildpresences":         discordgo.IntentsGuildPresences,
+	"guildmessages":          discordgo.IntentsGuildMessages,
+	"guildmessagereactions":  discordgo.IntentsGuildMessageReactions,
+	"guildmessagetyping":     discordgo.IntentsGuildMessageTyping,
+	"directmessages":         discordgo.IntentsDirectMessages,
+	"directmessagereactions": discordgo.IntentsDirectMessageReactions,
+	"directmessagetyping":    discordgo.IntentsDirectMessageTyping,
+	"messagecontent":         discordgo.IntentsMessageContent,
+}
+
+// a cached value and when it was last fetched or updated by an event
+type cacheEntry struct {
+	value   interface{}
+	updated time.Time
+}
+
+// hits and misses for one kind of cached value
+type cacheCounts struct {
+	hits   int
+	misses int
+}
+
+// channels, members and roles kept up to date by gateway events, falling back to the REST api once entries are older than cachettl
+type discordCache struct {
+	mutex    sync.Mutex
+	channels map[string]cacheEntry
+	members  map[string]cacheEntry
+	roles    map[string]cacheEntry
+	counts   map[string]*cacheCounts
+}
+
+var cache = discordCache{
+	channels: make(map[string]cacheEntry),
+	members:  make(map[string]cacheEntry),
+	roles:    make(map[string]cacheEntry),
+	counts:   map[string]*cacheCounts{"channels": {}, "members": {}, "roles": {}},
+}
+
+func init() {
+	registerFunction(builtinFunction{
+		name: "cacheStatus",
+		help: "Shows how often channels, members and roles are found in the cache",
+		run:  showCacheStatus,
+	})
+}
+
+// sets the gateway intents from intents, discordgo uses all unprivileged intents when unset
+func setupIntents(dg *discordgo.Session) {
+	if !viper.IsSet("intents") {
+		return
+	}
+
+	var intents discordgo.Intent
+	for _, name := range viper.GetStringSlice("intents") {
+		intent, ok := intentNames[strings.ToLower(name)]
+		if !ok {
+			logger.Error("Unknown gateway intent", "intent", name)
+			continue
+		}
+		intents |= intent
+	}
+
+	dg.Identify.Intents = intents
+
+	// without member events the cache can only refresh members from the api
+	if intents&discordgo.IntentsGuildMembers == 0 {
+		logger.Warn("The guildmembers intent is not set, cached members are refreshed from the api after cachettl")
+	}
+}
+
+// registers the handlers keeping the cache up to date
+func addCacheHandlers(dg *discordgo.Session) {
+	dg.AddHandler(func(s *discordgo.Session, g *discordgo.GuildCreate) {
+		cache.storeGuild(g.Guild)
+		resolveRoleNames(s, g.ID)
+	})
+	dg.AddHandler(func(s *discordgo.Session, c *discordgo.ChannelUpdate) {
+		cache.store(cache.channels, c.ID, c.Channel)
+	})
+	dg.AddHandler(func(s *discordgo.Session, c *discordgo.ChannelDelete) {
+		cache.remove(cache.channels, c.ID)
+	})
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+		cache.store(cache.members, memberCacheKey(m.GuildID, m.User), m.Member)
+	})
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+		cache.store(cache.members, memberCacheKey(m.GuildID, m.User), m.Member)
+	})
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+		cache.remove(cache.members, memberCacheKey(m.GuildID, m.User))
+	})
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.GuildRoleCreate) {
+		cache.storeRole(r.GuildID, r.Role)
+		resolveRoleNames(s, r.GuildID)
+	})
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.GuildRoleUpdate) {
+		cache.storeRole(r.GuildID, r.Role)
+		resolveRoleNames(s, r.GuildID)
+	})
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.GuildRoleDelete) {
+		cache.removeRole(r.GuildID, r.RoleID)
+		resolveRoleNames(s, r.GuildID)
+	})
+}
+
+// members are cached per guild
+func memberCacheKey(guildID string, user *discordgo.User) string {
+	if user == nil {
+		return guildID + "/"
+	}
+	return guildID + "/" + user.ID
+}
+
+// how long a cached value is used before it is fetched again
+func cacheTTL() time.Duration {
+	if viper.IsSet("cachettl") {
+		return getDuration("cachettl")
+	}
+	return 5 * time.Minute
+}
+
+// stores a value from an event or the api
+func (c *discordCache) store(entries map[string]cacheEntry, key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entries[key] = cacheEntry{value: value, updated: time.Now()}
+}
+
+// forgets a value
+func (c *discordCache) remove(entries map[string]cacheEntry, key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(entries, key)
+}
+
+// returns a value that is still fresh, counting the hit or miss
+func (c *discordCache) lookup(kind string, entries map[string]cacheEntry, key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := entries[key]
+	if ok && time.Since(entry.updated) < cacheTTL() {
+		c.counts[kind].hits++
+		return entry.value, true
+	}
+
+	c.counts[kind].misses++
+	return nil, false
+}
+
+// stores the roles, channels and members that come with a guild
+func (c *discordCache) storeGuild(guild *discordgo.Guild) {
+	if guild == nil {
+		return
+	}
+
+	roles := make(map[string]*discordgo.Role)
+	for _, role := range guild.Roles {
+		roles[role.ID] = role
+	}
+	c.store(c.roles, guild.ID, roles)
+
+	for _, channel := range guild.Channels {
+		c.store(c.channels, channel.ID, channel)
+	}
+
+	for _, member := range guild.Members {
+		c.store(c.members, memberCacheKey(guild.ID, member.User), member)
+	}
+}
+
+// updates one role of a guild, keeping the rest
+func (c *discordCache) storeRole(guildID string, role *discordgo.Role) {
+	if role == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// without the other roles of the guild the next lookup has to fetch them all
+	entry, ok := c.roles[guildID]
+	if !ok {
+		return
+	}
+
+	roles := make(map[string]*discordgo.Role)
+	for id, existing := range entry.value.(map[string]*discordgo.Role) {
+		roles[id] = existing
+	}
+	roles[role.ID] = role
+
+	c.roles[guildID] = cacheEntry{value: roles, updated: entry.updated}
+}
+
+// forgets one role of a guild
+func (c *discordCache) removeRole(guildID string, roleID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.roles[guildID]
+	if !ok {
+		return
+	}
+
+	roles := make(map[string]*discordgo.Role)
+	for id, existing := range entry.value.(map[string]*discordgo.Role) {
+		if id != roleID {
+			roles[id] = existing
+		}
+	}
+
+	c.roles[guildID] = cacheEntry{value: roles, updated: entry.updated}
+}
+
+// returns a channel from the cache, fetching it from the api when missing or stale
+func cachedChannel(s *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+	if value, ok := cache.lookup("channels", cache.channels, channelID); ok {
+		return value.(*discordgo.Channel), nil
+	}
+
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	cache.store(cache.channels, channelID, channel)
+
+	return channel, nil
+}
+
+// returns a guild member from the cache, fetching it from the api when missing or stale
+func cachedMember(s *discordgo.Session, guildID string, userID string) (*discordgo.Member, error) {
+	key := guildID + "/" + userID
+	if value, ok := cache.lookup("members", cache.members, key); ok {
+		return value.(*discordgo.Member), nil
+	}
+
+	member, err := s.GuildMember(guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	cache.store(cache.members, key, member)
+
+	return member, nil
+}
+
+// returns the roles of a guild by id from the cache, fetching them from the api when missing or stale
+func cachedRoles(s *discordgo.Session, guildID string) (map[string]*discordgo.Role, error) {
+	if value, ok := cache.lookup("roles", cache.roles, guildID); ok {
+		return value.(map[string]*discordgo.Role), nil
+	}
+
+	list, err := s.GuildRoles(guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]*discordgo.Role)
+	for _, role := range list {
+		roles[role.ID] = role
+	}
+	cache.store(cache.roles, guildID, roles)
+
+	return roles, nil
+}
+
+// custom command function showing how well the cache is working
+func showCacheStatus(ctx context.Context, request functionRequest) error {
+	cache.mutex.Lock()
+	sizes := map[string]int{"channels": len(cache.channels), "members": len(cache.members), "roles": len(cache.roles)}
+
+	var kinds []string
+	for kind := range cache.counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var lines []string
+	for _, kind := range kinds {
+		counts := cache.counts[kind]
+		rate := 0.0
+		if total := counts.hits + counts.misses; total > 0 {
+			rate = float64(counts.hits) * 100 / float64(total)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d cached, %d hits, %d misses, %.1f%% hit rate", kind, sizes[kind], counts.hits, counts.misses, rate))
+	}
+	cache.mutex.Unlock()
+
+	lines = append(lines, "Entries are refreshed after "+cacheTTL().String())
+
+	channelMessageCreate(request.Session, request.Message, strings.Join(lines, "\n"), true)
+
+	return nil
+}
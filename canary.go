@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// the session is not healthy once no heartbeat has been acknowledged for this long, discord heartbeats every 41 seconds or so
+const canaryHeartbeatAge = 2 * time.Minute
+
+// a heartbeat monitor the bot checks in with
+type canaryTarget struct {
+	name string
+	// url pings a url, healthchecks pings start then success or fail, kuma pushes up or down with the latency
+	kind       string
+	url        string
+	interval   time.Duration
+	maxbackoff time.Duration
+}
+
+// the outcome of the last check ins with a target
+type canaryState struct {
+	lastAttempt time.Time
+	lastSuccess time.Time
+	lastError   string
+	healthy     bool
+	failures    int
+	nextAttempt time.Time
+}
+
+var (
+	canaryMutex  sync.Mutex
+	canaryStates = make(map[string]*canaryState)
+)
+
+func init() {
+	registerFunction(builtinFunction{
+		name: "canaryStatus",
+		help: "Shows the last check in with each canary target",
+		run:  showCanaryStatus,
+	})
+}
+
+// returns the canary targets from canaries, canaryurl is kept as a plain url target
+func canaryTargets() []canaryTarget {
+	interval := 10 * time.Second
+	if viper.IsSet("canaryinterval") {
+		interval = getDuration("canaryinterval")
+	}
+
+	maxbackoff := 10 * time.Minute
+	if viper.IsSet("canarymaxbackoff") {
+		maxbackoff = getDuration("canarymaxbackoff")
+	}
+
+	var targets []canaryTarget
+
+	if viper.IsSet("canaryurl") {
+		targets = append(targets, canaryTarget{name: "canaryurl", kind: "url", url: viper.GetString("canaryurl"), interval: interval, maxbackoff: maxbackoff})
+	}
+
+	var names []string
+	for name := range viper.GetStringMap("canaries") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key := "canaries." + name
+
+		target := canaryTarget{name: name, kind: "url", url: viper.GetString(key + ".url"), interval: interval, maxbackoff: maxbackoff}
+		if viper.IsSet(key + ".type") {
+			target.kind = strings.ToLower(viper.GetString(key + ".type"))
+		}
+		if viper.IsSet(key + ".interval") {
+			target.interval = getDuration(key + ".interval")
+		}
+
+		if target.url == "" {
+			canaryLog.Error("No url configured for canary", "canary", name)
+			continue
+		}
+
+		switch target.kind {
+		case "url", "healthchecks", "kuma":
+		default:
+			canaryLog.Error("Unknown canary type, use url, healthchecks or kuma", "canary", name, "type", target.kind)
+			continue
+		}
+
+		if target.interval <= 0 {
+			canaryLog.Error("Invalid interval for canary", "canary", name)
+			continue
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+// starts checking in with every canary target
+func startCanaries(s *discordgo.Session) {
+	for _, target := range canaryTargets() {
+		canaryMutex.Lock()
+		canaryStates[target.name] = &canaryState{}
+		canaryMutex.Unlock()
+
+		go runCanary(s, target)
+	}
+}
+
+// checks in with a target on its interval, backing off exponentially while check ins fail
+func runCanary(s *discordgo.Session, target canaryTarget) {
+	failures := 0
+
+	for {
+		limit := target.maxbackoff
+		if limit < target.interval {
+			limit = target.interval
+		}
+
+		delay := target.interval
+		for i := 0; i < failures && delay < limit; i++ {
+			delay *= 2
+		}
+		if delay > limit {
+			delay = limit
+		}
+
+		canaryMutex.Lock()
+		canaryStates[target.name].nextAttempt = time.Now().Add(delay)
+		canaryMutex.Unlock()
+
+		time.Sleep(delay)
+
+		healthy, latency := sessionHealthy(s)
+		err := canaryCheckin(target, healthy, latency)
+
+		canaryMutex.Lock()
+		state := canaryStates[target.name]
+		state.lastAttempt = time.Now()
+		state.healthy = healthy
+		if err != nil {
+			failures++
+			state.lastError = err.Error()
+		} else {
+			failures = 0
+			state.lastError = ""
+			if healthy {
+				state.lastSuccess = state.lastAttempt
+			}
+		}
+		state.failures = failures
+		canaryMutex.Unlock()
+
+		if err != nil {
+			canaryLog.Error("Could not checkin to canary", "canary", target.name, "failures", failures, "error", err)
+		} else if !healthy {
+			canaryLog.Warn("Discord session is not healthy, canary not checked in", "canary", target.name)
+		} else {
+			canaryLog.Debug("Canary checkin", "canary", target.name, "latency", latency)
+		}
+	}
+}
+
+// checks the session is connected and discord is acknowledging its heartbeats, returns the heartbeat latency
+func sessionHealthy(s *discordgo.Session) (bool, time.Duration) {
+	s.RLock()
+	ready := s.DataReady
+	lastAck := s.LastHeartbeatAck
+	lastSent := s.LastHeartbeatSent
+	latency := s.HeartbeatLatency()
+	s.RUnlock()
+
+	// while a heartbeat is waiting for its ack the latency is at least as long as it has been waiting
+	if lastSent.After(lastAck) {
+		waiting := time.Since(lastSent)
+		return ready && waiting < canaryHeartbeatAge, waiting
+	}
+
+	return ready && time.Since(lastAck) < canaryHeartbeatAge, latency
+}
+
+// checks in with a target, only reporting success while the session is healthy
+func canaryCheckin(target canaryTarget, healthy bool, latency time.Duration) error {
+	switch target.kind {
+	case "healthchecks":
+		if err := canaryPing(target.url + "/start"); err != nil {
+			return err
+		}
+		if !healthy {
+			return canaryPing(target.url + "/fail")
+		}
+		return canaryPing(target.url)
+
+	case "kuma":
+		push, err := url.Parse(target.url)
+		if err != nil {
+			return err
+		}
+
+		query := push.Query()
+		if healthy {
+			query.Set("status", "up")
+			query.Set("msg", "OK")
+		} else {
+			query.Set("status", "down")
+			query.Set("msg", "Discord session not healthy")
+		}
+		query.Set("ping", strconv.FormatInt(latency.Milliseconds(), 10))
+		push.RawQuery = query.Encode()
+
+		return canaryPing(push.String())
+	}
+
+	// a plain url has no way to report a failure, so it is only pinged while healthy
+	if !healthy {
+		return nil
+	}
+	return canaryPing(target.url)
+}
+
+// requests a canary url, the body is always read and closed so connections are reused
+func canaryPing(pingurl string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pingurl, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("canary returned HTTPStatus: " + strconv.Itoa(resp.StatusCode))
+	}
+
+	return nil
+}
+
+// custom command function showing the last check in with each canary target
+func showCanaryStatus(ctx context.Context, request functionRequest) error {
+	canaryMutex.Lock()
+	var names []string
+	for name := range canaryStates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		state := canaryStates[name]
+
+		line := name + ": "
+		switch {
+		case state.lastAttempt.IsZero():
+			line += "not checked in yet"
+		case state.lastError != "":
+			line += fmt.Sprintf("failing %d times, last error %s", state.failures, state.lastError)
+		case !state.healthy:
+			line += "discord session not healthy"
+		default:
+			line += "ok"
+		}
+
+		if !state.lastSuccess.IsZero() {
+			line += ", last success " + time.Since(state.lastSuccess).Round(time.Second).String() + " ago"
+		}
+		line += ", next in " + time.Until(state.nextAttempt).Round(time.Second).String()
+
+		lines = append(lines, line)
+	}
+	canaryMutex.Unlock()
+
+	if len(lines) == 0 {
+		lines = append(lines, "No canaries are running")
+	}
+
+	healthy, latency := sessionHealthy(request.Session)
+	lines = append(lines, fmt.Sprintf("Discord session healthy: %t, latency %s", healthy, latency.Round(time.Millisecond)))
+
+	channelMessageCreate(request.Session, request.Message, strings.Join(lines, "\n"), true)
+
+	return nil
+}
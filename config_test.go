@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// replaces the settings with a yaml config for the length of a test
+func useConfig(t *testing.T, config string) {
+	t.Helper()
+
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+
+	t.Cleanup(viper.Reset)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// a function that commands can run with function: <name>
+type botFunction interface {
+	Name() string
+	Help() string
+	Execute(ctx context.Context, request functionRequest) error
+}
+
+// functions can optionally describe the arguments they take, used for usage, help and argument checks
+type functionArguments interface {
+	Arguments() []commandArgument
+}
+
+// everything a function needs to run a command
+type functionRequest struct {
+	Session *discordgo.Session
+	Message *discordgo.MessageCreate
+	commandRequest
+}
+
+// all registered functions by name
+var functions = make(map[string]botFunction)
+
+// registers a function so commands can use it, functions normally register themselves from init
+func registerFunction(function botFunction) {
+	if _, ok := functions[function.Name()]; ok {
+		logger.Error("Function is already registered", "function", function.Name())
+		return
+	}
+	functions[function.Name()] = function
+}
+
+// a function built into the bot
+type builtinFunction struct {
+	name      string
+	help      string
+	arguments []commandArgument
+	run       func(ctx context.Context, request functionRequest) error
+}
+
+func (f builtinFunction) Name() string {
+	return f.name
+}
+
+func (f builtinFunction) Help() string {
+	return f.help
+}
+
+func (f builtinFunction) Arguments() []commandArgument {
+	return f.arguments
+}
+
+func (f builtinFunction) Execute(ctx context.Context, request functionRequest) error {
+	return f.run(ctx, request)
+}
+
+// returns the function a command runs, if any
+func commandFunction(cmdkey string) (botFunction, bool) {
+	if !viper.IsSet(cmdkey + ".function") {
+		return nil, false
+	}
+
+	function, ok := functions[viper.GetString(cmdkey+".function")]
+	return function, ok
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+type SnapshotResponse struct {
+	EventID string `json:"event_id"`
+}
+
+func init() {
+	registerFunction(builtinFunction{
+		name: "cameraSnapshot",
+		help: "Takes a camera snapshot",
+		arguments: []commandArgument{
+			{name: "camera", help: "Camera to take a snapshot of"},
+		},
+		run: cameraSnapshot,
+	})
+
+	registerFunction(builtinFunction{
+		name: "cameraList",
+		help: "Lists the cameras",
+		run:  cameraList,
+	})
+}
+
+// custom command function to take a camera snapshot
+func cameraSnapshot(ctx context.Context, request functionRequest) error {
+	s, m := request.Session, request.Message
+
+	// get camera from message
+	camera := request.arg(0)
+
+	if camera == "" {
+		return errors.New("no camera given")
+	}
+
+	// Define the API endpoint
+	url := viper.GetString("cameraapiurl") + "/api/events/" + camera + "/Discord Snapshot/create"
+
+	// Create a POST request
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		integrationLog.Error("Cannot create camera request", "url", url, "error", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send the POST request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		integrationLog.Error("Cannot send camera request", "url", url, "error", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Check if the request was successful
+	if resp.StatusCode != http.StatusOK {
+		integrationLog.Error("Camera request failed", "url", url, "status", resp.StatusCode)
+		return fmt.Errorf("request failed with status: %d", resp.StatusCode)
+	}
+
+	// Read the response body
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		integrationLog.Error("Cannot read camera response", "url", url, "error", err)
+		return err
+	}
+
+	// Parse the JSON response
+	var response SnapshotResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		integrationLog.Error("Cannot parse camera response", "url", url, "error", err)
+		return err
+	}
+
+	privateMessageCreate(s, m.Author.ID, viper.GetString("camerasnapshoturl")+"/"+camera+"-"+response.EventID+".jpg", false)
+
+	return nil
+}
+
+// custom command function to list cameras
+func cameraList(ctx context.Context, request functionRequest) error {
+	s, m := request.Session, request.Message
+
+	// Define the API endpoint
+	url := viper.GetString("cameraapiurl") + "/api/config"
+
+	// Create a GET request
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		integrationLog.Error("Cannot create camera request", "url", url, "error", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send the GET request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		integrationLog.Error("Cannot send camera request", "url", url, "error", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Check if the request was successful
+	if resp.StatusCode != http.StatusOK {
+		integrationLog.Error("Camera request failed", "url", url, "status", resp.StatusCode)
+		return fmt.Errorf("request failed with status: %d", resp.StatusCode)
+	}
+
+	// Read the response body
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		integrationLog.Error("Cannot read camera response", "url", url, "error", err)
+		return err
+	}
+
+	var data map[string]interface{}
+
+	// Parse the JSON data
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		integrationLog.Error("Cannot parse camera response", "url", url, "error", err)
+		return err
+	}
+
+	// Extract the cameras object
+	cameras, ok := data["cameras"].(map[string]interface{})
+	if !ok {
+		integrationLog.Error("No cameras found in camera config", "url", url)
+		return errors.New("no cameras found in camera config")
+	}
+
+	// Concatenate keys into a single string with newline characters
+	var names []string
+	for key := range cameras {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	var result string
+	for _, name := range names {
+		result += name + "\n"
+	}
+
+	privateMessageCreate(s, m.Author.ID, "**Camera List**\n"+result, false)
+
+	return nil
+}
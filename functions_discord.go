@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+func init() {
+	registerFunction(builtinFunction{
+		name: "sendMessage",
+		help: "Sends a message as the bot to a channel",
+		arguments: []commandArgument{
+			{name: "channel_id", help: "Channel to send the message to"},
+			{name: "message", help: "Message to send"},
+		},
+		run: sendMessage,
+	})
+
+	registerFunction(builtinFunction{
+		name: "editMessage",
+		help: "Edits a message the bot has sent",
+		arguments: []commandArgument{
+			{name: "channel_id", help: "Channel the message is in"},
+			{name: "message_id", help: "Message to edit"},
+			{name: "message", help: "New message"},
+		},
+		run: editMessage,
+	})
+
+	registerFunction(builtinFunction{
+		name: "listEmoji",
+		help: "Lists all emoji of a guild",
+		arguments: []commandArgument{
+			{name: "guild_id", help: "Guild to list the emoji of, defaults to the current guild", optional: true},
+		},
+		run: listEmoji,
+	})
+}
+
+// custom command function for sending messages as the bot
+func sendMessage(ctx context.Context, request functionRequest) error {
+
+	// get channel ID
+	channelID := request.arg(0)
+
+	// Get the rest of the message ignoring the channel
+	message := request.restAfter(1)
+
+	// send message to channel
+	_, err := request.Session.ChannelMessageSend(channelID, message)
+	return err
+}
+
+// custom command function for editing messages as the bot
+func editMessage(ctx context.Context, request functionRequest) error {
+
+	// get channel ID
+	channelID := request.arg(0)
+
+	// get message ID
+	messageID := request.arg(1)
+
+	// Get the rest of the message ignoring the channel and message
+	message := request.restAfter(2)
+
+	// edits message in channel
+	_, err := request.Session.ChannelMessageEdit(channelID, messageID, message)
+	return err
+}
+
+// custom command function to list all Emoji
+func listEmoji(ctx context.Context, request functionRequest) error {
+	s, m := request.Session, request.Message
+
+	// get guild ID from message
+	guildID := request.arg(0)
+
+	if guildID == "" {
+		guildID = m.GuildID
+	}
+
+	if guildID == "" {
+		return errors.New("no guild id given")
+	}
+
+	emojis, err := s.GuildEmojis(guildID)
+	if err != nil {
+		logger.Error("Could not get emoji", "error", err)
+		return err
+	}
+
+	var message string
+
+	for _, emoji := range emojis {
+		if m.GuildID != "" {
+			message += "<:" + emoji.Name + ":" + emoji.ID + ">  `" + emoji.ID + "    " + emoji.Name + "`\n"
+		} else {
+			message += emoji.ID + "    " + emoji.Name + "\n"
+		}
+	}
+
+	if m.GuildID != "" {
+		channelMessageCreate(s, m, "**Emoji for "+guildID+"**\n"+message, false)
+	} else {
+		privateMessageCreate(s, m.Author.ID, "**Emoji for "+guildID+"**\n```"+message+"```", false)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	registerFunction(builtinFunction{
+		name: "apiHomeAssistant",
+		help: "Calls the Home Assistant API with the parameters configured for the channel",
+		run:  apiHomeAssistant,
+	})
+}
+
+// custom command function to call the Home Assistant API
+func apiHomeAssistant(ctx context.Context, request functionRequest) error {
+
+	channelID := request.Message.ChannelID
+
+	cmdkey := request.Key
+
+	if viper.IsSet(cmdkey + ".channels." + channelID) {
+		parameters := viper.GetStringMap(cmdkey)["channels"].(map[string]interface{})[channelID].(map[string]interface{})["parameters"]
+
+		parameterList := parameters.([]interface{})
+
+		for _, param := range parameterList {
+			if err := makeHomeAssistantAPIRequest(ctx, param.(string)); err != nil {
+				return err
+			}
+		}
+
+	}
+
+	return nil
+}
+
+// make Home Assistant API request
+func makeHomeAssistantAPIRequest(ctx context.Context, param string) error {
+	url := viper.GetString("homeassistanturl")
+
+	// Check if the url ends with "/"
+	if !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+
+	// Check if the param starts with "/" and remove
+	param = strings.TrimPrefix(param, "/")
+
+	token := viper.GetString("homeassistanttoken")
+
+	// JSON payload for calling the script (if needed)
+	payload := []byte(`{}`)
+
+	// Create a new HTTP request
+	req, err := http.NewRequestWithContext(ctx, "POST", url+param, ioutil.NopCloser(bytes.NewBuffer(payload)))
+	if err != nil {
+		integrationLog.Error("Cannot create home assistant request", "error", err)
+		return err
+	}
+
+	// Set the required headers
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send the request
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		integrationLog.Error("Cannot send home assistant request", "url", url+param, "error", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read and print the response body
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		integrationLog.Error("Cannot read home assistant response", "error", err)
+		return err
+	}
+
+	integrationLog.Debug("Home assistant response", "url", url+param, "status", resp.StatusCode, "body", string(body))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("home assistant returned HTTPStatus: %d", resp.StatusCode)
+	}
+
+	return nil
+}
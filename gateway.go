@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+)
+
+// connection events seen from the discord gateway
+type gatewayState struct {
+	mutex          sync.Mutex
+	connected      bool
+	ready          bool
+	connectedAt    time.Time
+	disconnectedAt time.Time
+	disconnects    int
+	reconnects     int
+}
+
+var gateway gatewayState
+
+func init() {
+	registerFunction(builtinFunction{
+		name: "gatewayStatus",
+		help: "Shows the state of the connection to discord",
+		run:  showGatewayStatus,
+	})
+}
+
+// registers the handlers tracking the connection to discord
+func addGatewayHandlers(dg *discordgo.Session) {
+	dg.AddHandler(gatewayConnect)
+	dg.AddHandler(gatewayDisconnect)
+	dg.AddHandler(gatewayReady)
+	dg.AddHandler(gatewayResumed)
+}
+
+// opens the connection to discord, retrying with backoff until it works or gatewayretries attempts have failed
+func openSession(dg *discordgo.Session) error {
+	delay := 5 * time.Second
+	if viper.IsSet("gatewayretrydelay") {
+		delay = getDuration("gatewayretrydelay")
+	}
+
+	maxbackoff := 5 * time.Minute
+	if viper.IsSet("gatewaymaxbackoff") {
+		maxbackoff = getDuration("gatewaymaxbackoff")
+	}
+
+	// zero retries forever
+	retries := 5
+	if viper.IsSet("gatewayretries") {
+		retries = viper.GetInt("gatewayretries")
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := dg.Open()
+		if err == nil {
+			return nil
+		}
+
+		// a bad token or intents will fail the same way every time
+		if isFatalGatewayError(err) {
+			return err
+		}
+
+		if retries > 0 && attempt >= retries {
+			return err
+		}
+
+		logger.Error("Cannot open connection to discord, retrying", "attempt", attempt, "delay", delay, "error", err)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxbackoff {
+			delay = maxbackoff
+		}
+	}
+}
+
+// gateway close codes that retrying cannot fix, such as an invalid token or intents the bot is not allowed
+var fatalCloseCodes = map[int]bool{
+	4004: true, // authentication failed
+	4010: true, // invalid shard
+	4011: true, // sharding required
+	4012: true, // invalid api version
+	4013: true, // invalid intents
+	4014: true, // disallowed intents
+}
+
+// checks if an error opening the session means the bot cannot connect without its config being changed
+func isFatalGatewayError(err error) bool {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return fatalCloseCodes[closeErr.Code]
+	}
+
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Response != nil {
+		return restErr.Response.StatusCode == http.StatusUnauthorized
+	}
+
+	return false
+}
+
+// discord connect handler, the websocket is open
+func gatewayConnect(s *discordgo.Session, c *discordgo.Connect) {
+	gateway.mutex.Lock()
+	gateway.connected = true
+	gateway.mutex.Unlock()
+
+	logger.Info("Connected to discord gateway")
+}
+
+// discord disconnect handler, discordgo reconnects by itself
+func gatewayDisconnect(s *discordgo.Session, d *discordgo.Disconnect) {
+	gateway.mutex.Lock()
+	// discordgo can send more than one disconnect for an outage, only the first counts
+	if gateway.connected || gateway.ready {
+		gateway.disconnects++
+		gateway.disconnectedAt = time.Now()
+	}
+	gateway.connected = false
+	gateway.ready = false
+	gateway.mutex.Unlock()
+
+	logger.Warn("Disconnected from discord gateway")
+}
+
+// discord ready handler, a new session has started
+func gatewayReady(s *discordgo.Session, r *discordgo.Ready) {
+	gatewayRestored(s, "ready")
+}
+
+// discord resumed handler, the previous session carried on
+func gatewayResumed(s *discordgo.Session, r *discordgo.Resumed) {
+	gatewayRestored(s, "resumed")
+}
+
+// records the session being usable again, after an outage catches up on missed reactions and lets ops know
+func gatewayRestored(s *discordgo.Session, how string) {
+	gateway.mutex.Lock()
+	gateway.connected = true
+	gateway.ready = true
+	gateway.connectedAt = time.Now()
+
+	// the first ready is the bot starting, not a reconnect
+	outage := time.Duration(0)
+	reconnected := !gateway.disconnectedAt.IsZero()
+	if reconnected {
+		outage = gateway.connectedAt.Sub(gateway.disconnectedAt)
+		gateway.reconnects++
+		gateway.disconnectedAt = time.Time{}
+	}
+	gateway.mutex.Unlock()
+
+	if !reconnected {
+		return
+	}
+
+	logger.Info("Reconnected to discord gateway", "how", how, "outage", outage.Round(time.Second))
+
+	// put back the bot's own reactions, then give roles to people who reacted while offline
+	go func() {
+		checkReactions(s)
+		reconcileReactionRoles(s)
+	}()
+
+	notifyafter := time.Duration(0)
+	if viper.IsSet("gatewaynotifyafter") {
+		notifyafter = getDuration("gatewaynotifyafter")
+	}
+
+	if outage >= notifyafter {
+		go notifyOps(s, fmt.Sprintf("Reconnected to discord after an outage of %s (%s)", outage.Round(time.Second), how))
+	}
+}
+
+// sends a notice to the ops channel and ops webhook, if configured
+func notifyOps(s *discordgo.Session, message string) {
+	if channelID := viper.GetString("opschannel"); channelID != "" {
+		if err := channelIDMessageCreate(s, channelID, message, false); err != nil {
+			opsLog.Error("Cannot send message to ops channel", "channel", channelID, "error", err)
+		}
+	}
+
+	if webhook := viper.GetString("opswebhook"); webhook != "" {
+		for _, chunk := range messageChunks(message) {
+			if err := postWebhook(webhook, chunk); err != nil {
+				opsLog.Error("Cannot send message to ops webhook", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// posts a message to a discord style webhook, which still works while the gateway is down
+func postWebhook(webhook string, message string) error {
+	payload, err := json.Marshal(map[string]string{"content": message})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("webhook returned HTTPStatus: " + strconv.Itoa(resp.StatusCode))
+	}
+
+	return nil
+}
+
+// custom command function showing the state of the connection to discord
+func showGatewayStatus(ctx context.Context, request functionRequest) error {
+	s := request.Session
+	_, latency := sessionHealthy(s)
+
+	gateway.mutex.Lock()
+	lines := []string{
+		fmt.Sprintf("Connected: %t", gateway.connected && gateway.ready),
+		fmt.Sprintf("Heartbeat latency: %s", latency.Round(time.Millisecond)),
+		fmt.Sprintf("Disconnects: %d, reconnects: %d", gateway.disconnects, gateway.reconnects),
+	}
+	if !gateway.connectedAt.IsZero() {
+		lines = append(lines, "Connected for: "+time.Since(gateway.connectedAt).Round(time.Second).String())
+	}
+	gateway.mutex.Unlock()
+
+	channelMessageCreate(s, request.Message, strings.Join(lines, "\n"), true)
+
+	return nil
+}
@@ -1,16 +1,16 @@
 module simple-discord-bot
 
-go 1.19
+go 1.21
 
 require (
 	github.com/bwmarrin/discordgo v0.26.1
+	github.com/gorilla/websocket v1.4.2
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.13.0
 )
 
 require (
 	github.com/fsnotify/fsnotify v1.5.4 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/magiconair/properties v1.8.6 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
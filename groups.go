@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// returns every way a command can be typed, using its name and the aliases of it and of the groups it belongs to
+func commandSpellings(name string, key string) []string {
+	base := name
+	var parents []string
+
+	if i := strings.LastIndex(key, ".subcommands."); i != -1 {
+		base = key[i+len(".subcommands."):]
+		parents = commandSpellings(strings.TrimSuffix(name, " "+base), key[:i])
+	}
+
+	own := []string{base}
+	for _, alias := range viper.GetStringSlice(key + ".aliases") {
+		own = append(own, strings.ToLower(alias))
+	}
+
+	// top level command
+	if parents == nil {
+		return own
+	}
+
+	var spellings []string
+	for _, parent := range parents {
+		for _, spelling := range own {
+			spellings = append(spellings, parent+" "+spelling)
+		}
+	}
+
+	return spellings
+}
+
+// returns the key of a command setting, falling back to the groups a command belongs to when it has not been set
+func inheritedKey(cmdkey string, setting string) string {
+	key := cmdkey
+
+	for !viper.IsSet(key + "." + setting) {
+		i := strings.LastIndex(key, ".subcommands.")
+		if i == -1 {
+			return cmdkey + "." + setting
+		}
+		key = key[:i]
+	}
+
+	return key + "." + setting
+}
+
+// checks if a command is a group that only holds subcommands
+func isCommandGroup(cmdkey string) bool {
+	if !viper.IsSet(cmdkey + ".subcommands") {
+		return false
+	}
+
+	for _, action := range []string{"message", "api", "file", "shell", "function", "steps"} {
+		if viper.IsSet(cmdkey + "." + action) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sends the help page of a group, listing the subcommands a user can run
+func showGroupHelp(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, group string, cmdkey string, commandkey string, user *discordgo.Member) {
+	var lines []string
+
+	for subcommand := range viper.GetStringMap(cmdkey + ".subcommands") {
+		subkey := cmdkey + ".subcommands." + subcommand
+
+		if !canRunCommand(messageSubject(s, m, guildID, user), subkey) {
+			continue
+		}
+
+		line := commandkey + " " + group + " " + subcommand
+		if help := viper.GetString(subkey + ".help"); help != "" {
+			line += " - " + help
+		}
+		lines = append(lines, line)
+	}
+
+	sort.Strings(lines)
+
+	helpMessage := group + " commands:\n"
+	if help := viper.GetString(cmdkey + ".help"); help != "" {
+		helpMessage = group + " - " + help + "\n"
+	}
+
+	if len(lines) == 0 {
+		helpMessage += "No commands available"
+	} else {
+		helpMessage += strings.Join(lines, "\n")
+	}
+
+	if viper.GetBool(cmdkey + ".secret") {
+		privateMessageCreate(s, m.Author.ID, helpMessage, true)
+	} else {
+		channelMessageCreate(s, m, helpMessage, true)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// returns the guild used for direct messages, defaults to defaultserverid
+func homeGuildID() string {
+	if viper.IsSet("homeguild") {
+		return viper.GetString("homeguild")
+	}
+	return viper.GetString("defaultserverid")
+}
+
+// returns the guild a message belongs to, direct messages resolve to the home guild
+func resolveGuildID(guildID string) string {
+	if guildID == "" {
+		return homeGuildID()
+	}
+	return guildID
+}
+
+// returns the viper key for a setting, preferring the guild specific setting when one has been configured
+func guildKey(guildID string, key string) string {
+	if guildID != "" && viper.IsSet("guilds."+guildID+"."+key) {
+		return "guilds." + guildID + "." + key
+	}
+	return key
+}
+
+// returns the viper key of every command available in a guild by its full name, including the subcommands of groups
+// guild commands override global commands of the same name
+func commandKeys(guildID string) map[string]string {
+	keys := make(map[string]string)
+
+	for command := range viper.GetStringMap("commands") {
+		addCommandKeys(keys, command, "commands."+command)
+	}
+
+	if guildID != "" {
+		for command := range viper.GetStringMap("guilds." + guildID + ".commands") {
+			// drop the global command and any of its subcommands
+			for name := range keys {
+				if name == command || strings.HasPrefix(name, command+" ") {
+					delete(keys, name)
+				}
+			}
+
+			addCommandKeys(keys, command, "guilds."+guildID+".commands."+command)
+		}
+	}
+
+	return keys
+}
+
+// adds a command and all its subcommands to a map of command keys
+func addCommandKeys(keys map[string]string, name string, key string) {
+	keys[name] = key
+
+	for subcommand := range viper.GetStringMap(key + ".subcommands") {
+		addCommandKeys(keys, name+" "+subcommand, key+".subcommands."+subcommand)
+	}
+}
+
+// returns every command name and alias available in a guild mapped to the command it runs
+func commandNames(guildID string) map[string]string {
+	names := make(map[string]string)
+
+	keys := commandKeys(guildID)
+
+	for command := range keys {
+		names[command] = command
+	}
+
+	// aliases never replace a real command name
+	for command, key := range keys {
+		for _, spelling := range commandSpellings(command, key) {
+			if _, ok := names[spelling]; !ok {
+				names[spelling] = command
+			}
+		}
+	}
+
+	return names
+}
+
+// returns the viper key for a command within a guild
+func commandKey(guildID string, command string) string {
+	if key, ok := commandKeys(guildID)[command]; ok {
+		return key
+	}
+	return "commands." + command
+}
+
+// returns all discord roles available in a guild, guild roles override global roles of the same name
+func guildDiscordRoles(guildID string) map[string]interface{} {
+	return mergeGuildMap(guildID, "discordroles")
+}
+
+// returns all tracked reactions for a guild, guild reactions override global reactions of the same name
+func guildReactions(guildID string) map[string]interface{} {
+	return mergeGuildMap(guildID, "reactions")
+}
+
+// returns every tracked reaction across the global config and all configured guilds
+func allReactions() []map[string]interface{} {
+	var reactions []map[string]interface{}
+
+	for _, v := range viper.GetStringMap("reactions") {
+		if m, ok := v.(map[string]interface{}); ok {
+			reactions = append(reactions, m)
+		}
+	}
+
+	for guildID := range viper.GetStringMap("guilds") {
+		for _, v := range viper.GetStringMap("guilds." + guildID + ".reactions") {
+			if m, ok := v.(map[string]interface{}); ok {
+				reactions = append(reactions, m)
+			}
+		}
+	}
+
+	return reactions
+}
+
+// merges a global config map with the same map from a guild
+func mergeGuildMap(guildID string, key string) map[string]interface{} {
+	merged := make(map[string]interface{})
+
+	for k, v := range viper.GetStringMap(key) {
+		merged[k] = v
+	}
+
+	if guildID != "" {
+		for k, v := range viper.GetStringMap("guilds." + guildID + "." + key) {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// an argument a command accepts
+type commandArgument struct {
+	name     string
+	help     string
+	optional bool
+}
+
+// a command listed in the help
+type helpEntry struct {
+	category string
+	command  string
+	help     string
+}
+
+// returns the arguments configured for a command, either as names or as maps with name, help and optional
+// commands running a function without configured arguments use the arguments the function describes
+func commandArguments(cmdkey string) []commandArgument {
+	var arguments []commandArgument
+
+	if !viper.IsSet(cmdkey + ".arguments") {
+		if function, ok := commandFunction(cmdkey); ok {
+			if schema, ok := function.(functionArguments); ok {
+				return schema.Arguments()
+			}
+		}
+	}
+
+	items, _ := viper.Get(cmdkey + ".arguments").([]interface{})
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			arguments = append(arguments, commandArgument{name: v})
+		case map[string]interface{}:
+			argument := commandArgument{name: fmt.Sprint(v["name"])}
+			if help, ok := v["help"].(string); ok {
+				argument.help = help
+			}
+			if optional, ok := v["optional"].(bool); ok {
+				argument.optional = optional
+			}
+			arguments = append(arguments, argument)
+		}
+	}
+
+	return arguments
+}
+
+// returns the help of a command, falling back to the help of the function it runs
+func commandHelpText(cmdkey string) string {
+	if help := viper.GetString(cmdkey + ".help"); help != "" {
+		return help
+	}
+
+	if function, ok := commandFunction(cmdkey); ok {
+		return function.Help()
+	}
+
+	return ""
+}
+
+// returns the category of a command, subcommands inherit the category of their group
+func commandCategory(cmdkey string) string {
+	category := viper.GetString(inheritedKey(cmdkey, "category"))
+	if category == "" {
+		return "General"
+	}
+	return category
+}
+
+func init() {
+	registerFunction(builtinFunction{
+		name: "showHelp",
+		help: "Lists the commands you can run",
+		arguments: []commandArgument{
+			{name: "command", help: "Command to show the details of", optional: true},
+		},
+		run: showHelp,
+	})
+}
+
+// custom command function to list all commands based on user permission, or show the detailed help of one command
+func showHelp(ctx context.Context, request functionRequest) error {
+	s, m := request.Session, request.Message
+
+	guildID := request.GuildID
+
+	user, _ := cachedMember(s, guildID, m.Author.ID)
+
+	commandkey := viper.GetString(guildKey(guildID, "commandkey"))
+
+	// help can be posted in the channel instead of being sent privately
+	channelID := m.ChannelID
+	inchannel := viper.GetBool(request.Key + ".inchannel")
+	if !inchannel {
+		channel, err := s.UserChannelCreate(m.Author.ID)
+		if err != nil {
+			commandLog.Error("Cannot create PM channel", "userid", m.Author.ID, "error", err)
+			return err
+		}
+		channelID = channel.ID
+	}
+
+	// detailed help for a single command
+	if wanted := strings.TrimSpace(request.Rest); wanted != "" {
+		helpMessage := "No help found for " + wanted
+		if found, ok := findCommand(guildID, wanted); ok {
+			if canRunCommand(messageSubject(s, m, guildID, user), found.Key) {
+				helpMessage = commandHelp(found.Command, found.Key, commandkey)
+			}
+		}
+
+		if inchannel {
+			channelMessageCreate(s, m, helpMessage, true)
+		} else {
+			privateMessageCreate(s, m.Author.ID, helpMessage, true)
+		}
+		return nil
+	}
+
+	subject := messageSubject(s, m, guildID, user)
+	pages := helpPages(subject)
+
+	_, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:    pages[0],
+		Components: helpComponents(0, len(pages), subject),
+	})
+	if err != nil {
+		commandLog.Error("Cannot send help", "userid", m.Author.ID, "error", err)
+	}
+
+	return err
+}
+
+// builds the help pages listing the commands a user can run grouped by category
+func helpPages(subject permissionSubject) []string {
+	guildID := subject.guildID
+	commandkey := viper.GetString(guildKey(guildID, "commandkey"))
+
+	var entries []helpEntry
+
+	for command, cmdkey := range commandKeys(guildID) {
+		help := commandHelpText(cmdkey)
+		if help == "" {
+			continue
+		}
+
+		if !canRunCommand(subject, cmdkey) {
+			continue
+		}
+
+		entries = append(entries, helpEntry{category: commandCategory(cmdkey), command: commandkey + " " + command, help: help})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].category != entries[j].category {
+			return entries[i].category < entries[j].category
+		}
+		return entries[i].command < entries[j].command
+	})
+
+	// pad commands to the longest so the help lines up
+	width := 0
+	for _, entry := range entries {
+		if len(entry.command) > width {
+			width = len(entry.command)
+		}
+	}
+
+	pagesize := 20
+	if viper.IsSet("help.pagesize") {
+		pagesize = viper.GetInt("help.pagesize")
+	}
+
+	// leave room for the header and code block on each page
+	maxlength := viper.GetInt("chunksize") - 100
+
+	var pages [][]string
+	var page []string
+	var length int
+	category := ""
+
+	for _, entry := range entries {
+		line := entry.command + strings.Repeat(" ", width-len(entry.command)) + " - " + entry.help
+
+		if len(page) >= pagesize || length+len(line) > maxlength {
+			pages = append(pages, page)
+			page = nil
+			length = 0
+		}
+
+		// start each category, and each page, with the category name
+		if entry.category != category || len(page) == 0 {
+			header := "\n" + entry.category + ":"
+			if entry.category == category {
+				header += " (continued)"
+			}
+			page = append(page, header)
+			length += len(header)
+			category = entry.category
+		}
+
+		page = append(page, line)
+		length += len(line)
+	}
+	pages = append(pages, page)
+
+	var formatted []string
+	for i, lines := range pages {
+		header := "Help Commands:"
+		if len(pages) > 1 {
+			header = "Help Commands (page " + strconv.Itoa(i+1) + "/" + strconv.Itoa(len(pages)) + "):"
+		}
+		header += "\n" + strings.Repeat("-", len(header))
+
+		footer := "\n\n" + commandkey + " help <command> for more details"
+
+		formatted = append(formatted, "```\n"+header+strings.Join(lines, "\n")+footer+"\n```")
+	}
+
+	return formatted
+}
+
+// returns the previous and next buttons for a page of help, no buttons are needed for a single page
+func helpComponents(page int, pages int, subject permissionSubject) []discordgo.MessageComponent {
+	if pages <= 1 {
+		return nil
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Prev",
+					Style:    discordgo.SecondaryButton,
+					CustomID: helpButtonID(page-1, subject),
+					Disabled: page == 0,
+				},
+				discordgo.Button{
+					Label:    "Next",
+					Style:    discordgo.SecondaryButton,
+					CustomID: helpButtonID(page+1, subject),
+					Disabled: page >= pages-1,
+				},
+			},
+		},
+	}
+}
+
+// returns the custom id of a help page button, help:<page>:<userid>:<guildid>:<channelid>
+// the guild and channel help was asked for are kept so every page is built for them, not for where the buttons are
+func helpButtonID(page int, subject permissionSubject) string {
+	return "help:" + strconv.Itoa(page) + ":" + subject.userID + ":" + subject.guildID + ":" + subject.channelID
+}
+
+// reads the page and who help was built for from the custom id of a help page button
+func parseHelpButtonID(customID string) (int, permissionSubject, bool) {
+	parts := strings.Split(customID, ":")
+	if len(parts) != 5 || parts[0] != "help" {
+		return 0, permissionSubject{}, false
+	}
+
+	page, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, permissionSubject{}, false
+	}
+
+	return page, permissionSubject{userID: parts[2], guildID: parts[3], channelID: parts[4]}, true
+}
+
+// discord interaction handler for the help page buttons
+func helpInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionMessageComponent {
+		return
+	}
+
+	page, subject, ok := parseHelpButtonID(i.MessageComponentData().CustomID)
+	if !ok {
+		return
+	}
+
+	var userid string
+	if i.Member != nil {
+		userid = i.Member.User.ID
+	} else if i.User != nil {
+		userid = i.User.ID
+	}
+
+	// only the user who asked for help can change the page
+	if userid != subject.userID {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredMessageUpdate})
+		return
+	}
+
+	// pages are built for the guild and channel help was asked in, help sent by dm has no guild of its own
+	subject.session = s
+	subject.member, _ = cachedMember(s, subject.guildID, userid)
+
+	pages := helpPages(subject)
+
+	if page < 0 {
+		page = 0
+	}
+	if page >= len(pages) {
+		page = len(pages) - 1
+	}
+
+	components := helpComponents(page, len(pages), subject)
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    pages[page],
+			Components: components,
+		},
+	})
+	if err != nil {
+		commandLog.Error("Cannot update help page", "userid", userid, "error", err)
+	}
+}
+
+// builds the detailed help of a command
+func commandHelp(name string, cmdkey string, commandkey string) string {
+	helpMessage := commandkey + " " + name + "\n" + strings.Repeat("-", len(commandkey)+1+len(name)) + "\n"
+
+	if description := viper.GetString(cmdkey + ".description"); description != "" {
+		helpMessage += description + "\n"
+	} else if help := commandHelpText(cmdkey); help != "" {
+		helpMessage += help + "\n"
+	}
+
+	helpMessage += "\nCategory: " + commandCategory(cmdkey) + "\n"
+
+	if aliases := viper.GetStringSlice(cmdkey + ".aliases"); len(aliases) > 0 {
+		helpMessage += "Aliases:  " + strings.Join(aliases, ", ") + "\n"
+	}
+
+	helpMessage += "Usage:    " + commandUsage(cmdkey, commandkey, name) + "\n"
+
+	if arguments := commandArguments(cmdkey); len(arguments) > 0 {
+		helpMessage += "\nArguments:\n"
+		for _, argument := range arguments {
+			line := "  " + argument.name
+			if argument.optional {
+				line += " (optional)"
+			}
+			if argument.help != "" {
+				line += " - " + argument.help
+			}
+			helpMessage += line + "\n"
+		}
+	}
+
+	if examples := viper.GetStringSlice(cmdkey + ".examples"); len(examples) > 0 {
+		helpMessage += "\nExamples:\n"
+		for _, example := range examples {
+			helpMessage += "  " + example + "\n"
+		}
+	}
+
+	// list subcommands of groups
+	var subcommands []string
+	for subcommand := range viper.GetStringMap(cmdkey + ".subcommands") {
+		subcommands = append(subcommands, subcommand)
+	}
+	if len(subcommands) > 0 {
+		sort.Strings(subcommands)
+		helpMessage += "\nSubcommands: " + strings.Join(subcommands, ", ") + "\n"
+	}
+
+	helpMessage += "\nRoles:    " + strings.Join(viper.GetStringSlice(inheritedKey(cmdkey, "roles")), ", ") + "\n"
+
+	if cooldown := commandCooldown(cmdkey); cooldown > 0 {
+		helpMessage += "Cooldown: " + cooldown.String() + "\n"
+	}
+
+	return helpMessage
+}
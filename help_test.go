@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestHelpButtonID(t *testing.T) {
+	tests := []struct {
+		page    int
+		subject permissionSubject
+	}{
+		{12, permissionSubject{userID: "1234567890123456789", guildID: "2234567890123456789", channelID: "3234567890123456789"}},
+		{0, permissionSubject{userID: "111", guildID: "", channelID: "333"}},
+	}
+
+	for _, test := range tests {
+		id := helpButtonID(test.page, test.subject)
+		if len(id) > 100 {
+			t.Errorf("helpButtonID() = %q is longer than discord allows", id)
+		}
+
+		page, subject, ok := parseHelpButtonID(id)
+		if !ok || page != test.page || subject != test.subject {
+			t.Errorf("parseHelpButtonID(%q) = %d %+v %v, want %d %+v", id, page, subject, ok, test.page, test.subject)
+		}
+	}
+
+	for _, id := range []string{"help:1:111", "help:x:111:222:333", "other:1:111:222:333"} {
+		if _, _, ok := parseHelpButtonID(id); ok {
+			t.Errorf("parseHelpButtonID(%q) accepted an invalid id", id)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// config files merged into the settings, in the order they were merged
+var configFiles []string
+
+// returns the files to merge, include globs in the order they are listed then the conf.d directory sorted by name
+func includedFiles(configdir string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+
+	add := func(file string) {
+		if absolute, err := filepath.Abs(file); err == nil {
+			file = absolute
+		}
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	// the main config is already loaded, so a glob matching it must not merge it again
+	if configfile := viper.ConfigFileUsed(); configfile != "" {
+		if absolute, err := filepath.Abs(configfile); err == nil {
+			configfile = absolute
+		}
+		seen[configfile] = true
+	}
+
+	for _, pattern := range viper.GetStringSlice("include") {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(configdir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %s: %w", pattern, err)
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			add(match)
+		}
+	}
+
+	// a conf.d directory next to the config is used unless includedir says otherwise
+	includedir := filepath.Join(configdir, "conf.d")
+	explicit := viper.IsSet("includedir")
+	if explicit {
+		includedir = viper.GetString("includedir")
+		if !filepath.IsAbs(includedir) {
+			includedir = filepath.Join(configdir, includedir)
+		}
+	}
+
+	entries, err := os.ReadDir(includedir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			if explicit {
+				logger.Warn("includedir does not exist", "dir", includedir)
+			}
+			return files, nil
+		}
+		return nil, err
+	}
+
+	// ReadDir sorts by name, so the merge order is the same every time
+	for _, entry := range entries {
+		extension := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (extension == ".yaml" || extension == ".yml") {
+			add(filepath.Join(includedir, entry.Name()))
+		}
+	}
+
+	return files, nil
+}
+
+// settings whose entries must only be defined in one file, mapped to what an entry is called in conflicts
+var uniqueSettings = map[string]string{
+	"commands":  "command",
+	"reactions": "reaction",
+}
+
+// returns the commands and reactions defined in settings, top level and per guild, mapped from their key to a description
+func definedNames(settings map[string]interface{}) map[string]string {
+	defined := make(map[string]string)
+
+	add := func(prefix string, settings map[string]interface{}) {
+		for setting, kind := range uniqueSettings {
+			if entries, ok := settings[setting].(map[string]interface{}); ok {
+				for name := range entries {
+					defined[prefix+setting+"."+name] = kind + " " + name
+				}
+			}
+		}
+	}
+
+	add("", settings)
+
+	if guilds, ok := settings["guilds"].(map[string]interface{}); ok {
+		for guildID, guild := range guilds {
+			if guildsettings, ok := guild.(map[string]interface{}); ok {
+				add("guilds."+guildID+".", guildsettings)
+			}
+		}
+	}
+
+	return defined
+}
+
+// merges included config files into the settings, failing if two files define the same command or reaction
+func loadIncludes(configdir string) error {
+	configFiles = []string{viper.ConfigFileUsed()}
+
+	files, err := includedFiles(configdir)
+	if err != nil {
+		return err
+	}
+
+	// where each command and reaction was first defined, to report conflicts
+	sources := make(map[string]string)
+	for key := range definedNames(viper.AllSettings()) {
+		sources[key] = viper.ConfigFileUsed()
+	}
+
+	var conflicts []string
+
+	for _, file := range files {
+		included := viper.New()
+		included.SetConfigFile(file)
+		if err := included.ReadInConfig(); err != nil {
+			return fmt.Errorf("cannot read include %s: %w", file, err)
+		}
+
+		settings := included.AllSettings()
+
+		for key, name := range definedNames(settings) {
+			if source, ok := sources[key]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("%s in %s is already defined in %s", name, file, source))
+				continue
+			}
+			sources[key] = file
+		}
+
+		if err := viper.MergeConfigMap(settings); err != nil {
+			return fmt.Errorf("cannot merge include %s: %w", file, err)
+		}
+
+		configFiles = append(configFiles, file)
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return errors.New(strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestIncludedFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"config.yaml":      "include:\n  - \"*.yaml\"\n",
+		"extra.yaml":       "commands: {}\n",
+		"conf.d/wiki.yaml": "commands: {}\n",
+		"conf.d/notes.txt": "",
+		"conf.d/other.yml": "commands: {}\n",
+		"unrelated/a.yaml": "commands: {}\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigFile(filepath.Join(dir, "config.yaml"))
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	included, err := includedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "extra.yaml"),
+		filepath.Join(dir, "conf.d/other.yml"),
+		filepath.Join(dir, "conf.d/wiki.yaml"),
+	}
+	if !reflect.DeepEqual(included, want) {
+		t.Errorf("includedFiles() = %q, want %q", included, want)
+	}
+}
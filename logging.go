@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// subsystems that can be given their own level under logging.levels
+const (
+	subsystemCommands     = "commands"
+	subsystemReactions    = "reactions"
+	subsystemCanary       = "canary"
+	subsystemIntegrations = "integrations"
+)
+
+// loggers used across the bot, these log as text to stderr until setupLogging has read the config
+var (
+	logger         = slog.Default()
+	commandLog     = logger.With("subsystem", subsystemCommands)
+	reactionLog    = logger.With("subsystem", subsystemReactions)
+	canaryLog      = logger.With("subsystem", subsystemCanary)
+	integrationLog = logger.With("subsystem", subsystemIntegrations)
+
+	// logs problems sending to ops, without forwarding them back to ops
+	opsLog = logger
+)
+
+// replaces values that must never reach the logs
+const redactedText = "[REDACTED]"
+
+// values removed from every log line, filled from the config by setupLogging
+var redactedValues []string
+
+// a handler that only passes on records at or above its own level, so each subsystem can have a different level
+type levelHandler struct {
+	level   slog.Leveler
+	handler slog.Handler
+}
+
+func (h levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.handler.Enabled(ctx, level)
+}
+
+func (h levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler.Handle(ctx, record)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{level: h.level, handler: h.handler.WithAttrs(attrs)}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{level: h.level, handler: h.handler.WithGroup(name)}
+}
+
+// parses a level name like debug, info, warn or error
+func parseLevel(key string, fallback slog.Level) slog.Level {
+	if !viper.IsSet(key) {
+		return fallback
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(viper.GetString(key))); err != nil {
+		logger.Error("Invalid log level", "setting", key, "level", viper.GetString(key))
+		return fallback
+	}
+
+	return level
+}
+
+// creates the loggers from the logging settings
+func setupLogging() {
+	collectRedactedValues()
+
+	var output io.Writer = os.Stderr
+	if file := viper.GetString("logging.file"); file != "" {
+		logfile, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			logger.Error("Cannot open log file, logging to stderr", "file", file, "error", err)
+		} else {
+			output = logfile
+		}
+	}
+
+	// the subsystem handlers filter by level, so the shared handler lets everything through
+	options := &slog.HandlerOptions{
+		Level:       slog.LevelDebug,
+		ReplaceAttr: redactAttr,
+	}
+
+	var base slog.Handler
+	if strings.ToLower(viper.GetString("logging.format")) == "json" {
+		base = slog.NewJSONHandler(output, options)
+	} else {
+		base = slog.NewTextHandler(output, options)
+	}
+
+	level := parseLevel("logging.level", slog.LevelInfo)
+
+	opsLog = slog.New(levelHandler{level: level, handler: base})
+
+	// warnings and errors are also queued for the ops channel
+	if opsEnabled() {
+		base = opsHandler{level: parseLevel("opslevel", slog.LevelWarn), handler: base}
+	}
+
+	subsystemLogger := func(subsystem string) *slog.Logger {
+		return slog.New(levelHandler{level: parseLevel("logging.levels."+subsystem, level), handler: base}).With("subsystem", subsystem)
+	}
+
+	logger = slog.New(levelHandler{level: level, handler: base})
+	commandLog = subsystemLogger(subsystemCommands)
+	reactionLog = subsystemLogger(subsystemReactions)
+	canaryLog = subsystemLogger(subsystemCanary)
+	integrationLog = subsystemLogger(subsystemIntegrations)
+
+	// anything still using the log package goes through the same handler
+	slog.SetDefault(logger)
+}
+
+// gathers the token and any secret looking settings so they can be removed from the logs
+func collectRedactedValues() {
+	values := viper.GetStringSlice("logging.redact")
+
+	if Token != "" {
+		values = append(values, Token)
+	}
+
+	for _, key := range secretSettings() {
+		// short values would redact ordinary words
+		if value, ok := viper.Get(key).(string); ok && len(value) >= 8 {
+			values = append(values, value)
+		}
+	}
+
+	// longest first so a value containing another is redacted whole
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	redactedValues = values
+}
+
+// removes secret values from a string
+func redact(text string) string {
+	for _, value := range redactedValues {
+		if value != "" {
+			text = strings.ReplaceAll(text, value, redactedText)
+		}
+	}
+	return text
+}
+
+// removes secret values from the message and attributes of a log record
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redact(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, redact(err.Error()))
+		}
+	}
+	return attr
+}
+
+// returns output that is safe to log, the output of secret commands is never logged
+func loggableOutput(cmdkey string, output string) string {
+	if viper.GetBool(cmdkey + ".secret") {
+		return redactedText
+	}
+	return strings.TrimSpace(output)
+}
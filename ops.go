@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// warnings and errors waiting to be sent to ops
+type opsBatch struct {
+	mutex   sync.Mutex
+	lines   []string
+	counts  map[string]int
+	dropped int
+}
+
+var opsQueue = opsBatch{counts: make(map[string]int)}
+
+// a handler that queues warnings and errors for ops before passing every record on
+type opsHandler struct {
+	level   slog.Level
+	attrs   []slog.Attr
+	handler slog.Handler
+}
+
+func (h opsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h opsHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= h.level {
+		opsQueue.add(formatOpsLine(record, h.attrs))
+	}
+	return h.handler.Handle(ctx, record)
+}
+
+func (h opsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return opsHandler{level: h.level, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...), handler: h.handler.WithAttrs(attrs)}
+}
+
+func (h opsHandler) WithGroup(name string) slog.Handler {
+	return opsHandler{level: h.level, attrs: h.attrs, handler: h.handler.WithGroup(name)}
+}
+
+// checks if anywhere is configured to receive ops messages
+func opsEnabled() bool {
+	return viper.GetString("opschannel") != "" || viper.GetString("opswebhook") != ""
+}
+
+// formats a log record as a single line for ops, such as: ERROR Function failed subsystem=commands user=bob error=...
+func formatOpsLine(record slog.Record, attrs []slog.Attr) string {
+	var line strings.Builder
+	line.WriteString(record.Level.String() + " " + record.Message)
+
+	write := func(attr slog.Attr) bool {
+		fmt.Fprintf(&line, " %s=%v", attr.Key, attr.Value)
+		return true
+	}
+	for _, attr := range attrs {
+		write(attr)
+	}
+	record.Attrs(write)
+
+	return redact(line.String())
+}
+
+// queues a line, repeats of a queued line are counted rather than queued again
+func (b *opsBatch) add(line string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.counts[line]; ok {
+		b.counts[line]++
+		return
+	}
+
+	// only so many lines are sent each interval, the rest are counted
+	maxlines := 20
+	if viper.IsSet("opsmaxlines") {
+		maxlines = viper.GetInt("opsmaxlines")
+	}
+	if len(b.lines) >= maxlines {
+		b.dropped++
+		return
+	}
+
+	b.lines = append(b.lines, line)
+	b.counts[line] = 1
+}
+
+// empties the queue, returning the message to send to ops
+func (b *opsBatch) take() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.lines) == 0 && b.dropped == 0 {
+		return ""
+	}
+
+	var message strings.Builder
+	for _, line := range b.lines {
+		message.WriteString(line)
+		if count := b.counts[line]; count > 1 {
+			fmt.Fprintf(&message, " (x%d)", count)
+		}
+		message.WriteString("\n")
+	}
+	if b.dropped > 0 {
+		fmt.Fprintf(&message, "... %d more suppressed\n", b.dropped)
+	}
+
+	b.lines = nil
+	b.counts = make(map[string]int)
+	b.dropped = 0
+
+	return message.String()
+}
+
+// sends queued warnings and errors to ops every opsinterval
+func startOpsForwarding(s *discordgo.Session) {
+	if !opsEnabled() {
+		return
+	}
+
+	interval := 30 * time.Second
+	if viper.IsSet("opsinterval") {
+		interval = getDuration("opsinterval")
+	}
+
+	if interval <= 0 {
+		opsLog.Error("Invalid opsinterval, not forwarding to ops", "interval", interval)
+		return
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			flushOps(s)
+		}
+	}()
+}
+
+// sends anything queued to ops now
+func flushOps(s *discordgo.Session) {
+	if message := opsQueue.take(); message != "" {
+		notifyOps(s, message)
+	}
+}
+
+// sends a startup or shutdown notice to ops, unless opsnotices is false
+func opsNotice(s *discordgo.Session, message string) {
+	if viper.IsSet("opsnotices") && !viper.GetBool("opsnotices") {
+		return
+	}
+	notifyOps(s, message)
+}
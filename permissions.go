@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// the user a permission check is for, and where they asked
+type permissionSubject struct {
+	session   *discordgo.Session
+	guildID   string
+	channelID string
+	member    *discordgo.Member
+	userID    string
+}
+
+// the outcome of checking a command for a user, with the steps that led to it
+type permissionDecision struct {
+	allowed bool
+	path    []string
+}
+
+// joins the terms of a rule such as "discord:hackers AND channel:workshop"
+var ruleSeparator = regexp.MustCompile(`(?i)\s+and\s+`)
+
+// discord permissions that can be required with perm:<name>, named as in the discord api
+var permissionNames = map[string]int64{
+	"ADMINISTRATOR":              discordgo.PermissionAdministrator,
+	"MANAGE_GUILD":               discordgo.PermissionManageServer,
+	"MANAGE_CHANNELS":            discordgo.PermissionManageChannels,
+	"MANAGE_ROLES":               discordgo.PermissionManageRoles,
+	"MANAGE_MESSAGES":            discordgo.PermissionManageMessages,
+	"MANAGE_THREADS":             discordgo.PermissionManageThreads,
+	"MANAGE_WEBHOOKS":            discordgo.PermissionManageWebhooks,
+	"MANAGE_NICKNAMES":           discordgo.PermissionManageNicknames,
+	"MANAGE_EMOJIS_AND_STICKERS": discordgo.PermissionManageEmojis,
+	"MANAGE_EVENTS":              discordgo.PermissionManageEvents,
+	"KICK_MEMBERS":               discordgo.PermissionKickMembers,
+	"BAN_MEMBERS":                discordgo.PermissionBanMembers,
+	"MODERATE_MEMBERS":           discordgo.PermissionModerateMembers,
+	"VIEW_AUDIT_LOG":             discordgo.PermissionViewAuditLogs,
+	"MENTION_EVERYONE":           discordgo.PermissionMentionEveryone,
+	"VIEW_CHANNEL":               discordgo.PermissionViewChannel,
+	"SEND_MESSAGES":              discordgo.PermissionSendMessages,
+}
+
+func init() {
+	registerFunction(builtinFunction{
+		name: "whoCan",
+		help: "Explains who is allowed to run a command",
+		arguments: []commandArgument{
+			{name: "command", help: "Command to explain"},
+		},
+		run: whoCan,
+	})
+
+	registerFunction(builtinFunction{
+		name: "canIRun",
+		help: "Explains whether you can run a command here and why",
+		arguments: []commandArgument{
+			{name: "command", help: "Command to check"},
+		},
+		run: canIRun,
+	})
+}
+
+// returns the subject for the author of a message
+func messageSubject(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, member *discordgo.Member) permissionSubject {
+	return permissionSubject{session: s, guildID: guildID, channelID: m.ChannelID, member: member, userID: m.Author.ID}
+}
+
+// splits a rule into its terms
+func ruleTerms(rule string) []string {
+	var terms []string
+	for _, term := range ruleSeparator.Split(strings.TrimSpace(rule), -1) {
+		if term = strings.TrimSpace(term); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// checks every term of a rule can be checked
+func isRuleValid(guildID string, rule string) bool {
+	terms := ruleTerms(rule)
+	if len(terms) == 0 {
+		return false
+	}
+
+	for _, term := range terms {
+		if !isTermValid(guildID, term) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checks a single term, the role types checked by isRoleValid plus channel: and perm:
+func isTermValid(guildID string, term string) bool {
+	termdetails := strings.SplitN(term, ":", 2)
+
+	switch strings.ToLower(termdetails[0]) {
+	case "channel":
+		_, ok := channelIDForTerm(guildID, termdetails)
+		return ok
+	case "perm":
+		_, ok := permissionForTerm(termdetails)
+		return ok
+	}
+
+	return isRoleValid(guildID, term)
+}
+
+// returns the channel id of a channel: term, given as an id or an alias from discordchannels
+func channelIDForTerm(guildID string, termdetails []string) (string, bool) {
+	if len(termdetails) != 2 {
+		return "", false
+	}
+
+	name := strings.TrimSpace(termdetails[1])
+	if id, ok := mergeGuildMap(guildID, "discordchannels")[strings.ToLower(name)]; ok && id != nil {
+		return fmt.Sprint(id), true
+	}
+
+	// channel ids are only digits
+	if name != "" && strings.Trim(name, "0123456789") == "" {
+		return name, true
+	}
+
+	return "", false
+}
+
+// returns the permission bit of a perm: term
+func permissionForTerm(termdetails []string) (int64, bool) {
+	if len(termdetails) != 2 {
+		return 0, false
+	}
+	permission, ok := permissionNames[strings.ToUpper(strings.TrimSpace(termdetails[1]))]
+	return permission, ok
+}
+
+// returns the server wide permissions of a member from the @everyone role and the roles they hold
+func memberPermissions(subject permissionSubject) int64 {
+	if subject.member == nil || subject.session == nil {
+		return 0
+	}
+
+	if guild, err := subject.session.State.Guild(subject.guildID); err == nil && guild.OwnerID == subject.userID {
+		return discordgo.PermissionAll | discordgo.PermissionAdministrator
+	}
+
+	roles, err := cachedRoles(subject.session, subject.guildID)
+	if err != nil {
+		logger.Error("Cannot get roles to check permissions", "guild", subject.guildID, "error", err)
+		return 0
+	}
+
+	var permissions int64
+	if everyone, ok := roles[subject.guildID]; ok {
+		permissions = everyone.Permissions
+	}
+	for _, id := range subject.member.Roles {
+		if role, ok := roles[id]; ok {
+			permissions |= role.Permissions
+		}
+	}
+
+	return permissions
+}
+
+// checks if the subject asked in a channel, or in a thread of it
+func inChannel(subject permissionSubject, channelID string) bool {
+	if subject.channelID == channelID {
+		return true
+	}
+
+	if subject.session == nil {
+		return false
+	}
+
+	channel, err := cachedChannel(subject.session, subject.channelID)
+	return err == nil && channel.IsThread() && channel.ParentID == channelID
+}
+
+// checks if a single term matches the subject, returning the roles it was inherited through
+func checkTerm(subject permissionSubject, term string, visited map[string]bool) (bool, []string) {
+	termdetails := strings.SplitN(term, ":", 2)
+
+	switch strings.ToLower(termdetails[0]) {
+	case "channel":
+		channelID, ok := channelIDForTerm(subject.guildID, termdetails)
+		return ok && inChannel(subject, channelID), nil
+	case "perm":
+		permission, ok := permissionForTerm(termdetails)
+		if !ok {
+			return false, nil
+		}
+		permissions := memberPermissions(subject)
+		return permissions&discordgo.PermissionAdministrator != 0 || permissions&permission == permission, nil
+	}
+
+	if isRoleValid(subject.guildID, term) && checkUserPerms(subject.guildID, term, subject.member, subject.userID) {
+		return true, nil
+	}
+
+	// roles that imply this one, such as admin implying members
+	visited[strings.ToLower(term)] = true
+	for _, holder := range inheritingRoles(subject.guildID, term) {
+		if visited[strings.ToLower(holder)] {
+			continue
+		}
+		if !isTermValid(subject.guildID, holder) {
+			logger.Warn("Invalid role in roleinheritance", "role", holder)
+			continue
+		}
+		if ok, via := checkTerm(subject, holder, visited); ok {
+			return true, append([]string{holder}, via...)
+		}
+	}
+
+	return false, nil
+}
+
+// returns the roles that directly imply a role under roleinheritance
+func inheritingRoles(guildID string, role string) []string {
+	var holders []string
+
+	for holder, implied := range mergeGuildMap(guildID, "roleinheritance") {
+		list, ok := implied.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, v := range list {
+			if strings.EqualFold(fmt.Sprint(v), role) {
+				holders = append(holders, holder)
+				break
+			}
+		}
+	}
+
+	sort.Strings(holders)
+
+	return holders
+}
+
+// checks every term of a rule, describing how each one matched
+func checkRule(subject permissionSubject, rule string) (bool, string) {
+	matched := true
+	var results []string
+
+	for _, term := range ruleTerms(rule) {
+		ok, via := checkTerm(subject, term, make(map[string]bool))
+
+		result := term + " no"
+		if ok {
+			result = term + " yes"
+			if len(via) > 0 {
+				result += " (via " + strings.Join(via, ", ") + ")"
+			}
+		}
+		results = append(results, result)
+
+		if !ok {
+			matched = false
+		}
+	}
+
+	return matched, strings.Join(results, ", ")
+}
+
+// returns the first roles or deny rule of a command that cannot be checked
+func invalidRule(guildID string, cmdkey string) (string, bool) {
+	for _, setting := range []string{"deny", "roles"} {
+		for _, rule := range viper.GetStringSlice(inheritedKey(cmdkey, setting)) {
+			if !isRuleValid(guildID, rule) {
+				return rule, true
+			}
+		}
+	}
+	return "", false
+}
+
+// decides if the subject can run a command, deny rules win over roles
+func evaluatePermissions(subject permissionSubject, cmdkey string) permissionDecision {
+	var decision permissionDecision
+
+	for _, rule := range viper.GetStringSlice(inheritedKey(cmdkey, "deny")) {
+		// a deny rule that cannot be checked denies everyone rather than being skipped
+		if !isRuleValid(subject.guildID, rule) {
+			decision.path = append(decision.path, "deny "+rule+": invalid, denied")
+			return decision
+		}
+
+		matched, detail := checkRule(subject, rule)
+		if matched {
+			decision.path = append(decision.path, "deny "+rule+": "+detail+", denied")
+			return decision
+		}
+		decision.path = append(decision.path, "deny "+rule+": "+detail)
+	}
+
+	rules := viper.GetStringSlice(inheritedKey(cmdkey, "roles"))
+	if len(rules) == 0 {
+		decision.path = append(decision.path, "no roles set, denied")
+		return decision
+	}
+
+	for _, rule := range rules {
+		if !isRuleValid(subject.guildID, rule) {
+			decision.path = append(decision.path, "allow "+rule+": invalid")
+			continue
+		}
+
+		matched, detail := checkRule(subject, rule)
+		if matched {
+			decision.path = append(decision.path, "allow "+rule+": "+detail+", allowed")
+			decision.allowed = true
+			return decision
+		}
+		decision.path = append(decision.path, "allow "+rule+": "+detail)
+	}
+
+	decision.path = append(decision.path, "no roles matched, denied")
+
+	return decision
+}
+
+// checks if a user can run a command
+func canRunCommand(subject permissionSubject, cmdkey string) bool {
+	return evaluatePermissions(subject, cmdkey).allowed
+}
+
+// describes a single term for whocan
+func describeTerm(guildID string, term string) string {
+	termdetails := strings.SplitN(term, ":", 2)
+	name := ""
+	if len(termdetails) == 2 {
+		name = strings.TrimSpace(termdetails[1])
+	}
+
+	var description string
+	switch strings.ToLower(termdetails[0]) {
+	case "all":
+		description = "everyone"
+	case "channel":
+		description = "in channel " + name
+	case "perm":
+		description = "with the " + strings.ToUpper(name) + " permission"
+	case "discord", "discord-name":
+		description = "with the discord role " + name
+	default:
+		users, _ := viper.GetStringMap("commandroles")[strings.ToLower(term)].([]interface{})
+		description = fmt.Sprintf("in the %s role (%d users)", term, len(users))
+	}
+
+	if holders := inheritingRoles(guildID, term); len(holders) > 0 {
+		description += ", or anyone holding " + strings.Join(holders, " or ")
+	}
+
+	return description
+}
+
+// describes a rule for whocan
+func describeRule(guildID string, rule string) string {
+	var parts []string
+	for _, term := range ruleTerms(rule) {
+		parts = append(parts, describeTerm(guildID, term))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// finds the command a permission function was asked about
+func explainedCommand(request functionRequest) (commandRequest, bool) {
+	wanted := strings.TrimSpace(request.Rest)
+	found, ok := findCommand(request.GuildID, wanted)
+	if !ok {
+		channelMessageCreate(request.Session, request.Message, "Unknown command: "+wanted, false)
+	}
+	return found, ok
+}
+
+// custom command function listing the rules that decide who can run a command
+func whoCan(ctx context.Context, request functionRequest) error {
+	found, ok := explainedCommand(request)
+	if !ok {
+		return nil
+	}
+
+	lines := []string{found.Command + ":"}
+
+	if deny := viper.GetStringSlice(inheritedKey(found.Key, "deny")); len(deny) > 0 {
+		lines = append(lines, "Never anyone:")
+		for _, rule := range deny {
+			lines = append(lines, "- "+describeRule(request.GuildID, rule))
+		}
+	}
+
+	roles := viper.GetStringSlice(inheritedKey(found.Key, "roles"))
+	if len(roles) == 0 {
+		lines = append(lines, "Nobody, no roles are set")
+	} else {
+		lines = append(lines, "Anyone:")
+		for _, rule := range roles {
+			lines = append(lines, "- "+describeRule(request.GuildID, rule))
+		}
+	}
+
+	channelMessageCreate(request.Session, request.Message, strings.Join(lines, "\n"), true)
+
+	return nil
+}
+
+// custom command function explaining whether the author can run a command here
+func canIRun(ctx context.Context, request functionRequest) error {
+	found, ok := explainedCommand(request)
+	if !ok {
+		return nil
+	}
+
+	s, m := request.Session, request.Message
+
+	member, _ := cachedMember(s, request.GuildID, m.Author.ID)
+	decision := evaluatePermissions(messageSubject(s, m, request.GuildID, member), found.Key)
+
+	lines := []string{found.Command + ":"}
+	lines = append(lines, decision.path...)
+
+	if channel, err := cachedChannel(s, m.ChannelID); err == nil && !isCommandLocationAllowed(request.GuildID, found.Key, resolveChannelLocation(s, channel)) {
+		lines = append(lines, "not allowed in this channel")
+		decision.allowed = false
+	}
+
+	if sc, available, err := isCommandAvailable(messageSubject(s, m, request.GuildID, member), found.Key); err != nil {
+		lines = append(lines, "schedule is invalid")
+		decision.allowed = false
+	} else if !available {
+		lines = append(lines, "not available now, it can be used "+sc.describe())
+		decision.allowed = false
+	}
+
+	if decision.allowed {
+		lines = append(lines, "You can run "+found.Command+" here")
+	} else {
+		lines = append(lines, "You cannot run "+found.Command+" here")
+	}
+
+	channelMessageCreate(s, m, strings.Join(lines, "\n"), true)
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const permissionsConfig = `
+commandroles:
+  admin:
+    - 111
+  members:
+    - 222
+  owners:
+    - 333
+roleinheritance:
+  owners:
+    - admin
+  admin:
+    - members
+discordroles:
+  hackers: 900
+  banned: 901
+discordchannels:
+  workshop: 555
+commands:
+  members:
+    roles:
+      - members
+  workshop:
+    roles:
+      - "discord:hackers AND channel:workshop"
+  notbanned:
+    roles:
+      - all
+    deny:
+      - "discord:banned"
+  managers:
+    roles:
+      - "perm:MANAGE_GUILD"
+  invaliddeny:
+    roles:
+      - all
+    deny:
+      - "discord:nosuchrole"
+  noroles:
+    help: "Nobody can run this"
+`
+
+func TestEvaluatePermissions(t *testing.T) {
+	useConfig(t, permissionsConfig)
+
+	session := &discordgo.Session{State: discordgo.NewState()}
+
+	// roles and channels come from the cache, so nothing is fetched from discord
+	cache.store(cache.roles, "guild", map[string]*discordgo.Role{
+		"guild": {ID: "guild", Permissions: discordgo.PermissionSendMessages},
+		"700":   {ID: "700", Permissions: discordgo.PermissionManageServer},
+		"701":   {ID: "701", Permissions: discordgo.PermissionAdministrator},
+	})
+	cache.store(cache.channels, "1", &discordgo.Channel{ID: "1", Type: discordgo.ChannelTypeGuildText})
+	cache.store(cache.channels, "556", &discordgo.Channel{ID: "556", Type: discordgo.ChannelTypeGuildPublicThread, ParentID: "555"})
+
+	tests := []struct {
+		name    string
+		command string
+		userID  string
+		roles   []string
+		channel string
+		allowed bool
+	}{
+		{"member in role", "members", "222", nil, "1", true},
+		{"admin inherits members", "members", "111", nil, "1", true},
+		{"owner inherits through admin", "members", "333", nil, "1", true},
+		{"not a member", "members", "444", nil, "1", false},
+		{"discord role in channel", "workshop", "444", []string{"900"}, "555", true},
+		{"discord role in thread of channel", "workshop", "444", []string{"900"}, "556", true},
+		{"discord role in another channel", "workshop", "444", []string{"900"}, "1", false},
+		{"channel without discord role", "workshop", "444", nil, "555", false},
+		{"not denied", "notbanned", "444", nil, "1", true},
+		{"denied", "notbanned", "444", []string{"901"}, "1", false},
+		{"has permission", "managers", "444", []string{"700"}, "1", true},
+		{"administrator has every permission", "managers", "444", []string{"701"}, "1", true},
+		{"missing permission", "managers", "444", nil, "1", false},
+		{"invalid deny rule denies everyone", "invaliddeny", "444", nil, "1", false},
+		{"no roles", "noroles", "111", nil, "1", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject := permissionSubject{
+				session:   session,
+				guildID:   "guild",
+				channelID: test.channel,
+				member:    &discordgo.Member{Roles: test.roles},
+				userID:    test.userID,
+			}
+
+			decision := evaluatePermissions(subject, "commands."+test.command)
+			if decision.allowed != test.allowed {
+				t.Errorf("allowed = %v, want %v, path %v", decision.allowed, test.allowed, decision.path)
+			}
+			if len(decision.path) == 0 {
+				t.Error("decision has no path")
+			}
+		})
+	}
+}
+
+func TestCheckTermInheritance(t *testing.T) {
+	useConfig(t, permissionsConfig)
+
+	tests := []struct {
+		name    string
+		term    string
+		userID  string
+		matched bool
+		via     []string
+	}{
+		{"direct", "members", "222", true, nil},
+		{"one level", "members", "111", true, []string{"admin"}},
+		{"two levels", "members", "333", true, []string{"admin", "owners"}},
+		{"no inheritance upwards", "owners", "222", false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject := permissionSubject{guildID: "guild", userID: test.userID}
+
+			matched, via := checkTerm(subject, test.term, make(map[string]bool))
+			if matched != test.matched || !reflect.DeepEqual(via, test.via) {
+				t.Errorf("checkTerm(%q) = %v %v, want %v %v", test.term, matched, via, test.matched, test.via)
+			}
+		})
+	}
+}
+
+func TestCheckTermInheritanceCycle(t *testing.T) {
+	useConfig(t, `
+commandroles:
+  a:
+    - 1
+  b:
+    - 2
+roleinheritance:
+  a:
+    - b
+  b:
+    - a
+`)
+
+	subject := permissionSubject{guildID: "guild", userID: "3"}
+	if matched, _ := checkTerm(subject, "a", make(map[string]bool)); matched {
+		t.Error("user in neither role matched")
+	}
+}
+
+func TestInheritingRoles(t *testing.T) {
+	useConfig(t, permissionsConfig)
+
+	tests := []struct {
+		role    string
+		holders []string
+	}{
+		{"members", []string{"admin"}},
+		{"MEMBERS", []string{"admin"}},
+		{"admin", []string{"owners"}},
+		{"owners", nil},
+	}
+
+	for _, test := range tests {
+		if holders := inheritingRoles("guild", test.role); !reflect.DeepEqual(holders, test.holders) {
+			t.Errorf("inheritingRoles(%q) = %v, want %v", test.role, holders, test.holders)
+		}
+	}
+}
+
+func TestRuleTerms(t *testing.T) {
+	tests := []struct {
+		rule  string
+		terms []string
+	}{
+		{"admin", []string{"admin"}},
+		{"discord:hackers AND channel:workshop", []string{"discord:hackers", "channel:workshop"}},
+		{"discord-name:Door Keepers and perm:MANAGE_GUILD", []string{"discord-name:Door Keepers", "perm:MANAGE_GUILD"}},
+		{"  ", nil},
+	}
+
+	for _, test := range tests {
+		if terms := ruleTerms(test.rule); !reflect.DeepEqual(terms, test.terms) {
+			t.Errorf("ruleTerms(%q) = %q, want %q", test.rule, terms, test.terms)
+		}
+	}
+}
+
+func TestRoleNameReferences(t *testing.T) {
+	useConfig(t, `
+roleinheritance:
+  "discord-name:Admins":
+    - "discord-name:Members"
+reactions:
+  door:
+    message_id: 1
+    scheduleroles:
+      - "discord-name:Keyholders"
+commands:
+  door:
+    roles:
+      - "discord-name:Hackers AND channel:123"
+    deny:
+      - "discord-name:Banned"
+    scheduleroles:
+      - "discord-name:Staff"
+`)
+
+	want := map[string]bool{"admins": true, "members": true, "keyholders": true, "hackers": true, "banned": true, "staff": true}
+	if names := roleNameReferences(""); !reflect.DeepEqual(names, want) {
+		t.Errorf("roleNameReferences() = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/spf13/viper"
+)
+
+// sent as JSON on stdin to a plugin
+type PluginRequest struct {
+	Function  string            `json:"function"`
+	Command   string            `json:"command"`
+	Args      []string          `json:"args"`
+	Rest      string            `json:"rest"`
+	Raw       string            `json:"raw"`
+	Options   map[string]string `json:"options"`
+	GuildID   string            `json:"guild_id"`
+	ChannelID string            `json:"channel_id"`
+	MessageID string            `json:"message_id"`
+	UserID    string            `json:"user_id"`
+	Username  string            `json:"username"`
+}
+
+// read as JSON from the stdout of a plugin
+type PluginResponse struct {
+	Message   string `json:"message"`
+	Private   bool   `json:"private"`
+	Codeblock bool   `json:"codeblock"`
+	Error     string `json:"error"`
+}
+
+// a function run by executing an external program that speaks JSON over stdin and stdout
+type pluginFunction struct {
+	name      string
+	help      string
+	path      string
+	args      []string
+	arguments []commandArgument
+	// shell profile the plugin runs with, defaults to shellprofile
+	profile string
+	// environment variables passed to the plugin as well as those of its profile
+	env []string
+}
+
+// returns the shell profile a plugin runs with. plugins never see the whole environment of the bot, which holds the
+// discord token, so without a profile restricting it only the variables in env and PATH are passed through
+func (f pluginFunction) shellProfile() (shellProfile, error) {
+	name := viper.GetString("shellprofile")
+	if f.profile != "" {
+		name = f.profile
+	}
+
+	profile, err := shellProfileByName(name)
+	if err != nil {
+		return profile, err
+	}
+
+	if !profile.restrictenv {
+		profile.restrictenv = true
+		profile.env = []string{"PATH"}
+	}
+	profile.env = append(append([]string{}, profile.env...), f.env...)
+
+	return profile, nil
+}
+
+func (f pluginFunction) Name() string {
+	return f.name
+}
+
+func (f pluginFunction) Help() string {
+	return f.help
+}
+
+func (f pluginFunction) Arguments() []commandArgument {
+	return f.arguments
+}
+
+func (f pluginFunction) Execute(ctx context.Context, request functionRequest) error {
+	s, m := request.Session, request.Message
+
+	payload, err := json.Marshal(PluginRequest{
+		Function:  f.name,
+		Command:   request.Command,
+		Args:      request.Args,
+		Rest:      request.Rest,
+		Raw:       request.Raw,
+		Options:   request.options(),
+		GuildID:   request.GuildID,
+		ChannelID: m.ChannelID,
+		MessageID: m.ID,
+		UserID:    m.Author.ID,
+		Username:  m.Author.Username,
+	})
+	if err != nil {
+		return err
+	}
+
+	profile, err := f.shellProfile()
+	if err != nil {
+		return err
+	}
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.Command(f.path, f.args...)
+	if err := applyShellProfile(cmd, profile); err != nil {
+		return err
+	}
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = newLimitedWriter(&stdout, profile.output)
+	cmd.Stderr = newLimitedWriter(&stderr, profile.output)
+
+	if err := runCommand(ctx, cmd); err != nil {
+		integrationLog.Error("Plugin failed", "plugin", f.name, "stderr", loggableOutput(request.Key, stderr.String()), "error", err)
+		return err
+	}
+
+	var response PluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return fmt.Errorf("plugin %s returned invalid JSON: %w", f.name, err)
+	}
+
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+
+	if response.Message == "" {
+		return nil
+	}
+
+	if response.Private {
+		privateMessageCreate(s, m.Author.ID, response.Message, response.Codeblock)
+	} else {
+		channelMessageCreate(s, m, response.Message, response.Codeblock)
+	}
+
+	return nil
+}
+
+// registers the external plugins configured under plugins
+func registerPlugins() {
+	for name := range viper.GetStringMap("plugins") {
+		key := "plugins." + name
+
+		if !viper.IsSet(key + ".path") {
+			integrationLog.Error("No path configured for plugin", "plugin", name)
+			continue
+		}
+
+		// viper lowercases keys, so the function name can be set explicitly
+		functionName := name
+		if viper.IsSet(key + ".name") {
+			functionName = viper.GetString(key + ".name")
+		}
+
+		registerFunction(pluginFunction{
+			name:      functionName,
+			help:      viper.GetString(key + ".help"),
+			path:      viper.GetString(key + ".path"),
+			args:      viper.GetStringSlice(key + ".args"),
+			arguments: commandArguments(key),
+			profile:   viper.GetString(key + ".shellprofile"),
+			env:       viper.GetStringSlice(key + ".env"),
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// returns the prefixes commands can start with in a guild, longest first so "!eeh" is tried before "!"
+func commandPrefixes(guildID string) []string {
+	var prefixes []string
+
+	if commandkey := viper.GetString(guildKey(guildID, "commandkey")); commandkey != "" {
+		prefixes = append(prefixes, commandkey)
+	}
+
+	// an empty prefix would make every message a command
+	for _, prefix := range viper.GetStringSlice(guildKey(guildID, "commandkeys")) {
+		if prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	sort.SliceStable(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+
+	return prefixes
+}
+
+// strips the command prefix from a message, returning the rest of the message in its original case
+func stripPrefix(s *discordgo.Session, guildID string, content string, isDM bool) (string, bool) {
+	for _, prefix := range commandPrefixes(guildID) {
+		if len(content) < len(prefix) || !strings.EqualFold(content[:len(prefix)], prefix) {
+			continue
+		}
+
+		rest := content[len(prefix):]
+
+		// prefixes ending in a letter or number need a space after them, so "!eeh" does not match "!eehwiki"
+		last := []rune(prefix)[len([]rune(prefix))-1]
+		if (unicode.IsLetter(last) || unicode.IsDigit(last)) && !strings.HasPrefix(rest, " ") {
+			continue
+		}
+
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			continue
+		}
+
+		return rest, true
+	}
+
+	// mentioning the bot works as a prefix
+	if viper.GetBool(guildKey(guildID, "mentionprefix")) && s.State.User != nil {
+		for _, mention := range []string{"<@" + s.State.User.ID + ">", "<@!" + s.State.User.ID + ">"} {
+			if strings.HasPrefix(content, mention+" ") {
+				if rest := strings.TrimLeft(content[len(mention):], " "); rest != "" {
+					return rest, true
+				}
+			}
+		}
+	}
+
+	// direct messages can skip the prefix entirely
+	if isDM && viper.GetBool("dmnoprefix") && strings.TrimSpace(content) != "" {
+		return strings.TrimLeft(content, " "), true
+	}
+
+	return "", false
+}
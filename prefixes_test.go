@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const prefixesConfig = `
+commandkey: "!eeh"
+commandkeys:
+  - "!"
+mentionprefix: true
+dmnoprefix: true
+guilds:
+  "123":
+    commandkey: "?bot"
+    commandkeys: []
+  "456":
+    commandkeys: ["!", ""]
+`
+
+func TestStripPrefix(t *testing.T) {
+	useConfig(t, prefixesConfig)
+
+	session := &discordgo.Session{State: discordgo.NewState()}
+	session.State.User = &discordgo.User{ID: "42"}
+
+	tests := []struct {
+		guildID   string
+		content   string
+		dm        bool
+		rest      string
+		iscommand bool
+	}{
+		{"", "!eeh wiki", false, "wiki", true},
+		{"", "!EEH Wiki", false, "Wiki", true},
+		{"", "!eehwiki", false, "eehwiki", true}, // the shorter ! prefix still matches
+		{"", "!wiki", false, "wiki", true},
+		{"", "!eeh", false, "eeh", true}, // too short for !eeh, so it is the eeh command with the ! prefix
+		{"", "!", false, "", false},
+		{"", "<@42> wiki", false, "wiki", true},
+		{"", "<@!42> wiki", false, "wiki", true},
+		{"", "<@42>wiki", false, "", false},
+		{"", "<@43> wiki", false, "", false},
+		{"", "wiki", false, "", false},
+		{"", "wiki", true, "wiki", true},
+		{"123", "?bot wiki", false, "wiki", true},
+		{"123", "!wiki", false, "", false},
+		{"456", "!wiki", false, "wiki", true},
+		{"456", "wiki", false, "", false},
+	}
+
+	for _, test := range tests {
+		rest, iscommand := stripPrefix(session, test.guildID, test.content, test.dm)
+		if rest != test.rest || iscommand != test.iscommand {
+			t.Errorf("stripPrefix(%q, dm %v) = %q %v, want %q %v", test.content, test.dm, rest, iscommand, test.rest, test.iscommand)
+		}
+	}
+}
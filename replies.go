@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// classes of failure that can be reported back to users
+const (
+	replyUnknownCommand   = "unknowncommand"
+	replyPermissionDenied = "permissiondenied"
+	replyMissingArguments = "missingarguments"
+	replyError            = "error"
+	replyCooldown         = "cooldown"
+	replyTimeout          = "timeout"
+	replyBusy             = "busy"
+	replyUnavailable      = "unavailable"
+)
+
+// replies used when none have been configured
+var defaultReplies = map[string]string{
+	replyUnknownCommand:   "Unknown command `{command}`{suggestions}",
+	replyPermissionDenied: "You do not have permission to run `{command}`",
+	replyMissingArguments: "Missing arguments for `{command}`, usage: `{usage}`",
+	replyError:            "Something went wrong running `{command}`, please try again later",
+	replyCooldown:         "`{command}` can be used again in {remaining}",
+	replyTimeout:          "`{command}` took longer than {timeout} and was stopped",
+	replyBusy:             "You already have commands waiting to run, please try again shortly",
+	replyUnavailable:      "`{command}` is not available right now, it can be used {schedule}",
+}
+
+// matches template options like {0} and {1}
+var templateOptionRegex = regexp.MustCompile(`\{(\d+)\}`)
+
+// sends a user facing reply for a failure class, cmdkey may be empty when no command was found
+func sendReply(s *discordgo.Session, m *discordgo.MessageCreate, guildID string, cmdkey string, class string, options map[string]string) {
+	if viper.IsSet(guildKey(guildID, "replies.enable")) && !viper.GetBool(guildKey(guildID, "replies.enable")) {
+		return
+	}
+
+	// commands can silence all replies
+	if cmdkey != "" && viper.GetBool(cmdkey+".quiet") {
+		return
+	}
+
+	message, ok := defaultReplies[class]
+	if cmdkey != "" && viper.IsSet(cmdkey+".replies."+class) {
+		message = viper.GetString(cmdkey + ".replies." + class)
+	} else if viper.IsSet(guildKey(guildID, "replies."+class)) {
+		message = viper.GetString(guildKey(guildID, "replies."+class))
+	} else if !ok {
+		return
+	}
+
+	// an empty reply suppresses it
+	if message == "" {
+		return
+	}
+
+	message = prepareTemplate(message, options)
+
+	delivery := viper.GetString(guildKey(guildID, "replies.delivery"))
+	if cmdkey != "" && viper.IsSet(cmdkey+".replies.delivery") {
+		delivery = viper.GetString(cmdkey + ".replies.delivery")
+	}
+
+	// commands triggered by a message cannot be answered ephemerally, so ephemeral replies go via private message
+	switch strings.ToLower(delivery) {
+	case "dm", "ephemeral":
+		privateMessageCreate(s, m.Author.ID, message, false)
+	default:
+		channelMessageCreate(s, m, message, false)
+	}
+}
+
+// makes text typed by a user safe to show in a reply, it cannot end the code span it is shown in or ping anyone
+func escapeUserText(text string) string {
+	text = strings.ReplaceAll(text, "`", "'")
+
+	// a zero width space after @ stops user, role, @everyone and @here mentions
+	return strings.ReplaceAll(text, "@", "@\u200b")
+}
+
+// reports a failed command, telling the user when it failed because it ran out of time. returns the status of the failure
+func sendFailureReply(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, guildID string, cmdkey string, options map[string]string) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		sendReply(s, m, guildID, cmdkey, replyTimeout, options)
+		return "timeout"
+	}
+
+	sendReply(s, m, guildID, cmdkey, replyError, options)
+	return "error"
+}
+
+// returns the number of arguments a command needs, either configured or worked out from its templates
+func requiredArguments(cmdkey string) int {
+	if viper.IsSet(cmdkey + ".minargs") {
+		return viper.GetInt(cmdkey + ".minargs")
+	}
+
+	// documented arguments are required unless marked optional
+	if arguments := commandArguments(cmdkey); len(arguments) > 0 {
+		required := 0
+		for _, argument := range arguments {
+			if !argument.optional {
+				required++
+			}
+		}
+		return required
+	}
+
+	templates := stepTemplates(cmdkey)
+	for _, action := range []string{"message", "api", "file", "shell"} {
+		templates = append(templates, viper.GetString(cmdkey+"."+action))
+	}
+
+	required := 0
+	for _, template := range templates {
+		for _, match := range templateOptionRegex.FindAllStringSubmatch(template, -1) {
+			option, _ := strconv.Atoi(match[1])
+			if option+1 > required {
+				required = option + 1
+			}
+		}
+	}
+
+	return required
+}
+
+// returns how a command should be used
+func commandUsage(cmdkey string, commandkey string, command string) string {
+	if viper.IsSet(cmdkey + ".usage") {
+		return viper.GetString(cmdkey + ".usage")
+	}
+
+	usage := commandkey + " " + command
+
+	if arguments := commandArguments(cmdkey); len(arguments) > 0 {
+		for _, argument := range arguments {
+			if argument.optional {
+				usage += " [" + argument.name + "]"
+			} else {
+				usage += " <" + argument.name + ">"
+			}
+		}
+		return usage
+	}
+
+	for i := 0; i < requiredArguments(cmdkey); i++ {
+		usage += " <" + strconv.Itoa(i) + ">"
+	}
+
+	return usage
+}
+
+// formats command suggestions for a reply
+func formatSuggestions(commandkey string, suggestions []string) string {
+	if len(suggestions) == 0 {
+		return ""
+	}
+
+	var formatted []string
+	for _, suggestion := range suggestions {
+		formatted = append(formatted, "`"+commandkey+" "+suggestion+"`")
+	}
+
+	return ", did you mean " + strings.Join(formatted, ", ") + "?"
+}
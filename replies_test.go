@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestEscapeUserText(t *testing.T) {
+	tests := []struct {
+		text    string
+		escaped string
+	}{
+		{"wiki", "wiki"},
+		{"x` @everyone `", "x' @\u200beveryone '"},
+		{"hi <@123> and <@&456>", "hi <@\u200b123> and <@\u200b&456>"},
+		{"@here", "@\u200bhere"},
+		{"```code```", "'''code'''"},
+	}
+
+	for _, test := range tests {
+		if escaped := escapeUserText(test.text); escaped != test.escaped {
+			t.Errorf("escapeUserText(%q) = %q, want %q", test.text, escaped, test.escaped)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// a command parsed from a message, shared by every action type and function
+type commandRequest struct {
+	GuildID string   // guild the command runs in, direct messages resolve to the home guild
+	Command string   // full name of the command, with aliases resolved
+	Key     string   // viper key holding the commands configuration
+	Raw     string   // the message without its prefix, in its original case
+	Args    []string // words typed after the command, in their original case
+	Rest    string   // everything typed after the command, keeping its original spacing
+}
+
+// finds the longest command matching the start of a message, command names are matched ignoring case
+func findCommand(guildID string, thecommand string) (commandRequest, bool) {
+	request := commandRequest{GuildID: guildID, Raw: thecommand}
+
+	isValidCommand := false
+
+	allparts := strings.Split(thecommand, " ")
+
+	// command names and aliases mapped to the command they run
+	allcommands := commandNames(guildID)
+
+	var checkthiscommand string = ""
+
+	for i := 0; i < len(allparts); i++ {
+		if i == 0 {
+			checkthiscommand = strings.ToLower(allparts[0])
+		} else {
+			checkthiscommand = checkthiscommand + " " + strings.ToLower(allparts[i])
+		}
+
+		// keep going looking for the longest matching combination, all remaining words are the arguments
+		if command, ok := allcommands[checkthiscommand]; ok {
+			isValidCommand = true
+			request.Command = command
+			request.Rest = strings.Join(allparts[i+1:], " ")
+		}
+	}
+
+	if isValidCommand {
+		request.Key = commandKey(guildID, request.Command)
+		request.Args = strings.Fields(request.Rest)
+	}
+
+	return request, isValidCommand
+}
+
+// returns the options used when templating, {0} {1} etc for each argument and {rest} for everything after the command
+func (r commandRequest) options() map[string]string {
+	options := make(map[string]string)
+
+	for i, arg := range r.Args {
+		options["{"+strconv.Itoa(i)+"}"] = arg
+	}
+
+	options["{rest}"] = r.Rest
+
+	return options
+}
+
+// returns an argument, or an empty string when it was not given
+func (r commandRequest) arg(i int) string {
+	if i < len(r.Args) {
+		return r.Args[i]
+	}
+	return ""
+}
+
+// returns everything after the first n arguments, keeping its original spacing
+func (r commandRequest) restAfter(n int) string {
+	rest := r.Rest
+
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeft(rest, " ")
+
+		end := strings.Index(rest, " ")
+		if end == -1 {
+			return ""
+		}
+		rest = rest[end:]
+	}
+
+	return strings.TrimLeft(rest, " ")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const requestConfig = `
+commands:
+  wiki:
+    message: "x"
+  "my name is":
+    message: "x"
+  camera:
+    aliases: [cam]
+    subcommands:
+      snapshot:
+        aliases: [snap]
+        api: "x"
+      list:
+        api: "x"
+`
+
+func TestFindCommand(t *testing.T) {
+	useConfig(t, requestConfig)
+
+	tests := []struct {
+		typed   string
+		valid   bool
+		command string
+		key     string
+		args    []string
+		rest    string
+	}{
+		{"wiki", true, "wiki", "commands.wiki", nil, ""},
+		{"WIKI Main_Page", true, "wiki", "commands.wiki", []string{"Main_Page"}, "Main_Page"},
+		{"my name is Joe  Bloggs", true, "my name is", "commands.my name is", []string{"Joe", "Bloggs"}, "Joe  Bloggs"},
+		{"camera snapshot Front", true, "camera snapshot", "commands.camera.subcommands.snapshot", []string{"Front"}, "Front"},
+		{"cam snap front", true, "camera snapshot", "commands.camera.subcommands.snapshot", []string{"front"}, "front"},
+		{"camera", true, "camera", "commands.camera", nil, ""},
+		{"camera other", true, "camera", "commands.camera", []string{"other"}, "other"},
+		{"my name", false, "", "", nil, ""},
+		{"nothing", false, "", "", nil, ""},
+	}
+
+	for _, test := range tests {
+		request, valid := findCommand("", test.typed)
+		if valid != test.valid {
+			t.Errorf("findCommand(%q) valid = %v, want %v", test.typed, valid, test.valid)
+			continue
+		}
+		if request.Raw != test.typed {
+			t.Errorf("findCommand(%q) raw = %q", test.typed, request.Raw)
+		}
+		if !valid {
+			continue
+		}
+		if request.Command != test.command || request.Key != test.key || !sameArgs(request.Args, test.args) || request.Rest != test.rest {
+			t.Errorf("findCommand(%q) = %q %q %q %q, want %q %q %q %q", test.typed, request.Command, request.Key, request.Args, request.Rest, test.command, test.key, test.args, test.rest)
+		}
+	}
+}
+
+func TestRequestOptions(t *testing.T) {
+	request := commandRequest{Args: []string{"Front", "HD"}, Rest: "Front  HD"}
+
+	want := map[string]string{"{0}": "Front", "{1}": "HD", "{rest}": "Front  HD"}
+	if options := request.options(); !reflect.DeepEqual(options, want) {
+		t.Errorf("options() = %v, want %v", options, want)
+	}
+
+	if arg := request.arg(2); arg != "" {
+		t.Errorf("arg(2) = %q, want empty", arg)
+	}
+}
+
+// compares arguments, treating no arguments and an empty list as the same
+func sameArgs(a []string, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// where a message was sent from, threads are tracked against their parent channel
+type channelLocation struct {
+	channelID  string
+	parentID   string
+	categoryID string
+	isThread   bool
+	isDM       bool
+}
+
+// works out the channel, parent channel and category a message was sent in
+func resolveChannelLocation(s *discordgo.Session, chanl *discordgo.Channel) channelLocation {
+	loc := channelLocation{
+		channelID: chanl.ID,
+		isDM:      chanl.GuildID == "",
+	}
+
+	if chanl.IsThread() {
+		loc.isThread = true
+		loc.parentID = chanl.ParentID
+
+		// the category of a thread is the category of its parent channel
+		parent, err := cachedChannel(s, chanl.ParentID)
+		if err == nil {
+			loc.categoryID = parent.ParentID
+		}
+	} else {
+		loc.categoryID = chanl.ParentID
+	}
+
+	return loc
+}
+
+// checks if a command may be run from a location
+func isCommandLocationAllowed(guildID string, cmdkey string, loc channelLocation) bool {
+	// subcommands inherit the restrictions of their group
+	dm := strings.ToLower(viper.GetString(inheritedKey(cmdkey, "dm")))
+
+	if loc.isDM {
+		return dm != "deny"
+	}
+
+	if dm == "only" {
+		return false
+	}
+
+	// guild wide channel restrictions apply before the commands own restrictions
+	if !isLocationAllowed(guildKey(guildID, "channels"), loc) {
+		return false
+	}
+
+	return isLocationAllowed(inheritedKey(cmdkey, "channels"), loc)
+}
+
+// checks a location against the allow, deny, categories and threads settings stored under a key
+func isLocationAllowed(key string, loc channelLocation) bool {
+	for _, denied := range viper.GetStringSlice(key + ".deny") {
+		if denied == loc.channelID || denied == loc.parentID || (loc.categoryID != "" && denied == loc.categoryID) {
+			return false
+		}
+	}
+
+	if loc.isThread && viper.IsSet(key+".threads") && !viper.GetBool(key+".threads") {
+		return false
+	}
+
+	allowed := viper.GetStringSlice(key + ".allow")
+	categories := viper.GetStringSlice(key + ".categories")
+
+	// no allow lists set, so everywhere is allowed
+	if len(allowed) == 0 && len(categories) == 0 {
+		return true
+	}
+
+	for _, allow := range allowed {
+		if allow == loc.channelID || allow == loc.parentID {
+			return true
+		}
+	}
+
+	if loc.categoryID != "" {
+		for _, category := range categories {
+			if category == loc.categoryID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// returns the reply to send when a command is used somewhere it is not allowed, empty means send nothing
+func channelDeniedMessage(guildID string, cmdkey string) string {
+	if key := inheritedKey(cmdkey, "channeldeniedmessage"); viper.IsSet(key) {
+		return viper.GetString(key)
+	}
+	return viper.GetString(guildKey(guildID, "channeldeniedmessage"))
+}
+
+// when each user last ran each command, used to enforce cooldowns
+var (
+	cooldowns      = make(map[string]time.Time)
+	cooldownsMutex sync.Mutex
+)
+
+// returns the cooldown of a command, configured as seconds or a duration like 1m30s
+func commandCooldown(cmdkey string) time.Duration {
+	key := inheritedKey(cmdkey, "cooldown")
+	if !viper.IsSet(key) {
+		return 0
+	}
+
+	return getDuration(key)
+}
+
+// returns how long a command may run before it is cancelled, subcommands inherit the timeout of their group
+func commandTimeout(guildID string, cmdkey string) time.Duration {
+	timeout := 60 * time.Second
+
+	if key := inheritedKey(cmdkey, "timeout"); viper.IsSet(key) {
+		timeout = getDuration(key)
+	} else if viper.IsSet(guildKey(guildID, "commandtimeout")) {
+		timeout = getDuration(guildKey(guildID, "commandtimeout"))
+	}
+
+	if timeout <= 0 {
+		return 60 * time.Second
+	}
+
+	return timeout
+}
+
+// checks if a user is still waiting for a command to cool down, returning how long is left. starts the cooldown when none is left
+func checkCooldown(cmdkey string, userid string) time.Duration {
+	cooldown := commandCooldown(cmdkey)
+	if cooldown == 0 {
+		return 0
+	}
+
+	cooldownsMutex.Lock()
+	defer cooldownsMutex.Unlock()
+
+	if remaining := time.Until(cooldowns[userid+":"+cmdkey].Add(cooldown)); remaining > 0 {
+		return remaining
+	}
+
+	cooldowns[userid+":"+cmdkey] = time.Now()
+
+	return 0
+}
+
+// gives back a cooldown started by checkCooldown when the command did not run, the earlier use had already expired
+func releaseCooldown(cmdkey string, userid string) {
+	cooldownsMutex.Lock()
+	defer cooldownsMutex.Unlock()
+
+	delete(cooldowns, userid+":"+cmdkey)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// prefix of a role given by its name in discord, such as discord-name:Hackers
+const roleNamePrefix = "discord-name:"
+
+var (
+	roleNamesMutex sync.RWMutex
+	// role ids by guild and lowercased role name, names that are missing or ambiguous are left out
+	resolvedRoleNames = make(map[string]map[string]string)
+)
+
+// returns the guilds the bot is configured for, the home guild and any under guilds
+func configuredGuilds() []string {
+	guilds := make(map[string]bool)
+	if home := homeGuildID(); home != "" {
+		guilds[home] = true
+	}
+	for guildID := range viper.GetStringMap("guilds") {
+		guilds[guildID] = true
+	}
+
+	var list []string
+	for guildID := range guilds {
+		list = append(list, guildID)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// returns the lowercased role names used by discord-name: terms anywhere rules are used in a guild
+func roleNameReferences(guildID string) map[string]bool {
+	names := make(map[string]bool)
+
+	addRule := func(rule string) {
+		for _, term := range ruleTerms(rule) {
+			if strings.HasPrefix(strings.ToLower(term), roleNamePrefix) {
+				names[strings.ToLower(strings.TrimSpace(term[len(roleNamePrefix):]))] = true
+			}
+		}
+	}
+
+	for _, cmdkey := range commandKeys(guildID) {
+		for _, setting := range []string{"roles", "deny", "scheduleroles"} {
+			for _, rule := range viper.GetStringSlice(cmdkey + "." + setting) {
+				addRule(rule)
+			}
+		}
+	}
+
+	for holder, implied := range mergeGuildMap(guildID, "roleinheritance") {
+		addRule(holder)
+		for _, rule := range stringList(implied) {
+			addRule(rule)
+		}
+	}
+
+	for _, v := range guildReactions(guildID) {
+		if reaction, ok := v.(map[string]interface{}); ok {
+			if roles, ok := mapValue(reaction, "scheduleroles"); ok {
+				for _, rule := range stringList(roles) {
+					addRule(rule)
+				}
+			}
+		}
+	}
+
+	return names
+}
+
+// resolves discord-name: roles in every configured guild
+func resolveAllRoleNames(s *discordgo.Session) {
+	for _, guildID := range configuredGuilds() {
+		resolveRoleNames(s, guildID)
+	}
+}
+
+// resolves the discord-name: roles of a guild against its roles, warning about names that are missing or ambiguous
+func resolveRoleNames(s *discordgo.Session, guildID string) {
+	references := roleNameReferences(guildID)
+	resolved := make(map[string]string)
+
+	if len(references) > 0 {
+		roles, err := cachedRoles(s, guildID)
+		if err != nil {
+			logger.Error("Cannot get roles to resolve role names", "guild", guildID, "error", err)
+			return
+		}
+
+		byName := make(map[string][]string)
+		for _, role := range roles {
+			name := strings.ToLower(role.Name)
+			byName[name] = append(byName[name], role.ID)
+		}
+
+		for name := range references {
+			ids := byName[name]
+			switch len(ids) {
+			case 0:
+				logger.Warn("Role name not found in guild, commands using it cannot be run", "guild", guildID, "role", name)
+			case 1:
+				resolved[name] = ids[0]
+			default:
+				sort.Strings(ids)
+				logger.Warn("Role name matches more than one role in guild, commands using it cannot be run", "guild", guildID, "role", name, "ids", strings.Join(ids, ","))
+			}
+		}
+	}
+
+	roleNamesMutex.Lock()
+	resolvedRoleNames[guildID] = resolved
+	roleNamesMutex.Unlock()
+}
+
+// returns the id of a discord-name: role in a guild
+func roleIDByName(guildID string, name string) (string, bool) {
+	roleNamesMutex.RLock()
+	defer roleNamesMutex.RUnlock()
+
+	id, ok := resolvedRoleNames[guildID][strings.ToLower(strings.TrimSpace(name))]
+	return id, ok
+}
+
+// returns the id of a discord: role alias in a guild, ids can be written as numbers or strings
+func discordRoleID(guildID string, alias string) (string, bool) {
+	id, ok := guildDiscordRoles(guildID)[alias]
+	if !ok || id == nil {
+		return "", false
+	}
+	return fmt.Sprint(id), true
+}
+
+// checks if a member holds a role
+func memberHasRole(user *discordgo.Member, roleID string) bool {
+	if user == nil {
+		return false
+	}
+	for _, id := range user.Roles {
+		if id == roleID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// argument the bot is re-executed with to apply sandbox limits before running a shell command
+const sandboxArg = "--sandbox-exec"
+
+// environment variable passing the sandbox limits to the re-executed bot
+const sandboxEnv = "SIMPLE_DISCORD_BOT_SANDBOX"
+
+// how a shell command is run, configured under shellprofiles
+type shellProfile struct {
+	name        string
+	dir         string
+	env         []string
+	restrictenv bool
+	uid         int
+	gid         int
+	setuser     bool
+	cputime     int
+	memory      uint
+	output      uint
+	nonewprivs  bool
+}
+
+// checks if a profile needs the bot to re-execute itself to apply limits
+func (p shellProfile) needsHelper() bool {
+	return p.cputime > 0 || p.memory > 0 || p.nonewprivs
+}
+
+// shell operators that could run executables other than the one checked against shellallow
+const shellOperators = ";&|`$<>()\n"
+
+// returns the shell profile of a command, subcommands inherit the profile of their group and shellprofile sets a default
+func commandShellProfile(cmdkey string) (shellProfile, error) {
+	name := viper.GetString("shellprofile")
+	if key := inheritedKey(cmdkey, "shellprofile"); viper.IsSet(key) {
+		name = viper.GetString(key)
+	}
+
+	return shellProfileByName(name)
+}
+
+// returns a shell profile from shellprofiles, an empty name runs as the bot does without its secrets
+func shellProfileByName(name string) (shellProfile, error) {
+	if name == "" {
+		return shellProfile{}, nil
+	}
+
+	key := "shellprofiles." + name
+	if !viper.IsSet(key) {
+		return shellProfile{}, fmt.Errorf("shell profile %s not found", name)
+	}
+
+	profile := shellProfile{
+		name:        name,
+		dir:         viper.GetString(key + ".dir"),
+		env:         viper.GetStringSlice(key + ".env"),
+		restrictenv: viper.IsSet(key + ".env"),
+		cputime:     viper.GetInt(key + ".cputime"),
+		memory:      viper.GetSizeInBytes(key + ".memory"),
+		output:      viper.GetSizeInBytes(key + ".output"),
+		nonewprivs:  viper.GetBool(key + ".nonewprivs"),
+	}
+
+	if viper.IsSet(key+".uid") || viper.IsSet(key+".gid") {
+		profile.setuser = true
+		profile.uid = viper.GetInt(key + ".uid")
+		profile.gid = viper.GetInt(key + ".gid")
+	}
+
+	return profile, nil
+}
+
+// checks a shell command only runs an executable from shellallow, when shellallow is set
+func checkShellAllowed(command string, profile shellProfile) error {
+	allowed := viper.GetStringSlice("shellallow")
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	// operators would let a command run more than the checked executable
+	if strings.ContainsAny(command, shellOperators) {
+		return errors.New("shell operators are not allowed when shellallow is set")
+	}
+
+	words := strings.Fields(command)
+	if len(words) == 0 {
+		return errors.New("empty command")
+	}
+
+	executable := words[0]
+	if !strings.Contains(executable, "/") {
+		found, err := exec.LookPath(executable)
+		if err != nil {
+			return fmt.Errorf("executable %s not found", executable)
+		}
+		executable = found
+	} else if profile.dir != "" && !filepath.IsAbs(executable) {
+		executable = filepath.Join(profile.dir, executable)
+	}
+
+	executable = filepath.Clean(executable)
+
+	for _, allow := range allowed {
+		if filepath.Clean(allow) == executable {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("executable %s is not in shellallow", executable)
+}
+
+// builds the command that runs a shell command for a command, applying its shell profile
+func shellCommand(cmdkey string, command string) (*exec.Cmd, shellProfile, error) {
+	profile, err := commandShellProfile(cmdkey)
+	if err != nil {
+		return nil, profile, err
+	}
+
+	if err := checkShellAllowed(command, profile); err != nil {
+		return nil, profile, err
+	}
+
+	cmd := exec.Command(viper.GetString("shell"), "-c", command)
+	if err := applyShellProfile(cmd, profile); err != nil {
+		return nil, profile, err
+	}
+
+	return cmd, profile, nil
+}
+
+// sets the directory, environment and sandbox limits of a profile on a command
+func applyShellProfile(cmd *exec.Cmd, profile shellProfile) error {
+	cmd.Dir = profile.dir
+
+	// only pass through the environment variables the profile allows, otherwise everything but the bot's secrets
+	if profile.restrictenv {
+		cmd.Env = []string{}
+		for _, name := range profile.env {
+			if value, ok := os.LookupEnv(name); ok {
+				cmd.Env = append(cmd.Env, name+"="+value)
+			}
+		}
+	} else {
+		cmd.Env = secretFreeEnvironment()
+	}
+
+	return sandboxCommand(cmd, profile)
+}
+
+// returns the bot's environment without the variables holding its secrets, the envprefix overrides and any
+// variable a secret setting was read from
+func secretFreeEnvironment() []string {
+	secrets := make(map[string]bool)
+	for _, key := range secretSettings() {
+		if value := viper.GetString(key); value != "" {
+			secrets[value] = true
+		}
+	}
+
+	prefix := strings.ToUpper(envPrefix()) + "_"
+
+	var env []string
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		if strings.HasPrefix(strings.ToUpper(name), prefix) || name == "DISCORD_TOKEN" || secrets[value] {
+			continue
+		}
+		env = append(env, variable)
+	}
+
+	return env
+}
+
+// a writer that keeps at most a number of bytes and drops the rest, zero means no limit
+type limitedWriter struct {
+	writer    io.Writer
+	remaining uint
+	limited   bool
+	truncated bool
+}
+
+func newLimitedWriter(writer io.Writer, limit uint) *limitedWriter {
+	return &limitedWriter{writer: writer, remaining: limit, limited: limit > 0}
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if !w.limited {
+		return w.writer.Write(p)
+	}
+
+	written := len(p)
+	if uint(len(p)) > w.remaining {
+		p = p[:w.remaining]
+		w.truncated = true
+	}
+	w.remaining -= uint(len(p))
+
+	if len(p) > 0 {
+		if _, err := w.writer.Write(p); err != nil {
+			return 0, err
+		}
+	}
+
+	// report everything as written so the command is not killed by a broken pipe
+	return written, nil
+}
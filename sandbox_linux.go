@@ -0,0 +1,108 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// prctl option stopping a process and its children from gaining privileges
+const prSetNoNewPrivs = 38
+
+// applies a shell profile to a command, running it as another user and re-executing the bot to apply limits
+func sandboxCommand(cmd *exec.Cmd, profile shellProfile) error {
+	if profile.setuser {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(profile.uid), Gid: uint32(profile.gid)}
+	}
+
+	if !profile.needsHelper() {
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot find the bot executable to apply sandbox limits: %w", err)
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	limits := fmt.Sprintf("cputime=%d,memory=%d,nonewprivs=%t", profile.cputime, profile.memory, profile.nonewprivs)
+
+	cmd.Args = append([]string{self, sandboxArg}, cmd.Args...)
+	cmd.Path = self
+	cmd.Env = append(env, sandboxEnv+"="+limits)
+
+	return nil
+}
+
+// runs inside the re-executed bot, applies the sandbox limits then replaces itself with the command
+func runSandboxHelper(args []string) {
+	// prctl and setrlimit apply to the calling thread, so stay on the thread that runs exec
+	runtime.LockOSThread()
+
+	limits := os.Getenv(sandboxEnv)
+	os.Unsetenv(sandboxEnv)
+
+	fail := func(message string, err error) {
+		fmt.Fprintf(os.Stderr, "sandbox: %s: %v\n", message, err)
+		os.Exit(126)
+	}
+
+	if len(args) == 0 {
+		fail("no command", nil)
+	}
+
+	for _, limit := range strings.Split(limits, ",") {
+		parts := strings.SplitN(limit, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "cputime", "memory":
+			value, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				fail("invalid "+parts[0], err)
+			}
+			if value == 0 {
+				continue
+			}
+
+			resource := syscall.RLIMIT_CPU
+			if parts[0] == "memory" {
+				resource = syscall.RLIMIT_AS
+			}
+
+			if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: value}); err != nil {
+				fail("cannot set "+parts[0]+" limit", err)
+			}
+		case "nonewprivs":
+			if parts[1] != "true" {
+				continue
+			}
+			if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+				fail("cannot set no new privileges", errno)
+			}
+		}
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		fail("cannot find "+args[0], err)
+	}
+
+	err = syscall.Exec(path, args, os.Environ())
+	fail("cannot run "+path, err)
+}
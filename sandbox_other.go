@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// shell profiles can only change the directory and environment on this platform
+func sandboxCommand(cmd *exec.Cmd, profile shellProfile) error {
+	if profile.setuser || profile.needsHelper() {
+		return errors.New("shell profile " + profile.name + " needs linux for uid, gid, limits and nonewprivs")
+	}
+	return nil
+}
+
+// sandbox limits are only applied on linux
+func runSandboxHelper(args []string) {
+	fmt.Fprintln(os.Stderr, "sandbox: only supported on linux")
+	os.Exit(126)
+}
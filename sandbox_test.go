@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSecretFreeEnvironment(t *testing.T) {
+	useConfig(t, `
+discordtoken: "bot-token"
+homeassistanttoken: "ha-token"
+`)
+
+	tests := []struct {
+		name  string
+		value string
+		kept  bool
+	}{
+		{"SDB_TEST_SETTING", "anything", false},
+		{"DISCORD_TOKEN", "bot-token", false},
+		{"TEST_HA_SECRET", "ha-token", false},
+		{"TEST_PLAIN", "plain", true},
+	}
+
+	for _, test := range tests {
+		t.Setenv(test.name, test.value)
+	}
+
+	env := make(map[string]bool)
+	for _, variable := range secretFreeEnvironment() {
+		env[variable] = true
+	}
+
+	for _, test := range tests {
+		if kept := env[test.name+"="+test.value]; kept != test.kept {
+			t.Errorf("%s kept = %v, want %v", test.name, kept, test.kept)
+		}
+	}
+
+	if path := os.Getenv("PATH"); path != "" && !env["PATH="+path] {
+		t.Error("PATH was not kept")
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// reactions the bot removed because they were outside their schedule, so removeReaction leaves the users roles alone
+var (
+	botRemovedMutex     sync.Mutex
+	botRemovedReactions = make(map[string]time.Time)
+)
+
+// days that can be listed in a schedule window
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// a time range on some days of the week, in minutes since midnight. a range ending before it starts runs past midnight
+type scheduleWindow struct {
+	days map[time.Weekday]bool
+	from int
+	to   int
+}
+
+// when a command or reaction is available
+type schedule struct {
+	location *time.Location
+	windows  []scheduleWindow
+	// dates the schedule is closed all day, as 2006-01-02 or 01-02 for every year
+	holidays map[string]bool
+}
+
+// parses a time of day like 09:30, 24:00 ends a window at midnight
+func parseTimeOfDay(value string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	total := hours*60 + minutes
+	if hours < 0 || minutes < 0 || minutes > 59 || total > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	return total, nil
+}
+
+// returns a value in a map written in any case, viper does not lowercase maps nested in lists
+func mapValue(settings map[string]interface{}, key string) (interface{}, bool) {
+	for name, value := range settings {
+		if strings.EqualFold(name, key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// returns a list setting as strings
+func stringList(value interface{}) []string {
+	var list []string
+	switch typed := value.(type) {
+	case []interface{}:
+		for _, v := range typed {
+			list = append(list, fmt.Sprint(v))
+		}
+	case []string:
+		list = typed
+	case string:
+		list = strings.Split(typed, ",")
+	}
+	return list
+}
+
+// parses one window of a schedule, a window without days is every day and one without times is all day
+func parseScheduleWindow(item interface{}) (scheduleWindow, error) {
+	window := scheduleWindow{days: make(map[time.Weekday]bool), from: 0, to: 24 * 60}
+
+	settings, ok := item.(map[string]interface{})
+	if !ok {
+		return window, fmt.Errorf("window is not a map")
+	}
+
+	if days, ok := mapValue(settings, "days"); ok {
+		for _, day := range stringList(days) {
+			weekday, ok := weekdayNames[strings.ToLower(strings.TrimSpace(day))]
+			if !ok {
+				return window, fmt.Errorf("invalid day %q", day)
+			}
+			window.days[weekday] = true
+		}
+	}
+	if len(window.days) == 0 {
+		for _, weekday := range weekdayNames {
+			window.days[weekday] = true
+		}
+	}
+
+	var err error
+	if from, ok := mapValue(settings, "from"); ok {
+		if window.from, err = parseTimeOfDay(fmt.Sprint(from)); err != nil {
+			return window, err
+		}
+	}
+	if to, ok := mapValue(settings, "to"); ok {
+		if window.to, err = parseTimeOfDay(fmt.Sprint(to)); err != nil {
+			return window, err
+		}
+	}
+
+	return window, nil
+}
+
+// parses a schedule given inline or by its name under schedules
+func parseSchedule(guildID string, value interface{}) (schedule, error) {
+	sc := schedule{location: time.Local, holidays: make(map[string]bool)}
+
+	settings, ok := value.(map[string]interface{})
+	if !ok {
+		name := strings.ToLower(fmt.Sprint(value))
+		named, found := mergeGuildMap(guildID, "schedules")[name]
+		if !found {
+			return sc, fmt.Errorf("unknown schedule %s", name)
+		}
+		if settings, ok = named.(map[string]interface{}); !ok {
+			return sc, fmt.Errorf("schedule %s is not a map", name)
+		}
+	}
+
+	if timezone, ok := mapValue(settings, "timezone"); ok {
+		location, err := time.LoadLocation(fmt.Sprint(timezone))
+		if err != nil {
+			return sc, fmt.Errorf("invalid timezone %v: %w", timezone, err)
+		}
+		sc.location = location
+	}
+
+	if windows, ok := mapValue(settings, "windows"); ok {
+		list, ok := windows.([]interface{})
+		if !ok {
+			return sc, fmt.Errorf("windows is not a list")
+		}
+		for i, item := range list {
+			window, err := parseScheduleWindow(item)
+			if err != nil {
+				return sc, fmt.Errorf("window %d: %w", i+1, err)
+			}
+			sc.windows = append(sc.windows, window)
+		}
+	}
+
+	if holidays, ok := mapValue(settings, "holidays"); ok {
+		for _, date := range stringList(holidays) {
+			date = strings.TrimSpace(date)
+			if _, err := time.Parse("2006-01-02", date); err != nil {
+				if _, err := time.Parse("01-02", date); err != nil {
+					return sc, fmt.Errorf("invalid holiday %q", date)
+				}
+			}
+			sc.holidays[date] = true
+		}
+	}
+
+	return sc, nil
+}
+
+// checks if a schedule is open at a time, a schedule without windows is always open outside holidays
+func (sc schedule) isOpen(now time.Time) bool {
+	now = now.In(sc.location)
+
+	if sc.holidays[now.Format("2006-01-02")] || sc.holidays[now.Format("01-02")] {
+		return false
+	}
+
+	if len(sc.windows) == 0 {
+		return true
+	}
+
+	minutes := now.Hour()*60 + now.Minute()
+	yesterday := now.AddDate(0, 0, -1).Weekday()
+
+	for _, window := range sc.windows {
+		if window.from <= window.to {
+			if window.days[now.Weekday()] && minutes >= window.from && minutes < window.to {
+				return true
+			}
+			continue
+		}
+
+		// the window runs past midnight, the early hours belong to the day before
+		if window.days[now.Weekday()] && minutes >= window.from {
+			return true
+		}
+		if window.days[yesterday] && minutes < window.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// describes the windows of a schedule for the unavailable reply
+func (sc schedule) describe() string {
+	if len(sc.windows) == 0 {
+		return "outside holidays"
+	}
+
+	var descriptions []string
+	for _, window := range sc.windows {
+		var days []time.Weekday
+		for day := range window.days {
+			days = append(days, day)
+		}
+		sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+
+		var names []string
+		if len(days) < 7 {
+			for _, day := range days {
+				names = append(names, day.String()[:3])
+			}
+		} else {
+			names = append(names, "every day")
+		}
+
+		descriptions = append(descriptions, fmt.Sprintf("%s %02d:%02d-%02d:%02d", strings.Join(names, ","), window.from/60, window.from%60, window.to/60, window.to%60))
+	}
+
+	return strings.Join(descriptions, ", ") + " " + sc.location.String()
+}
+
+// returns the schedule of a command, subcommands use the schedule of their group. ok is false when it has none
+func commandSchedule(guildID string, cmdkey string) (schedule, bool, error) {
+	key := inheritedKey(cmdkey, "schedule")
+	if !viper.IsSet(key) {
+		return schedule{}, false, nil
+	}
+
+	sc, err := parseSchedule(guildID, viper.Get(key))
+	return sc, true, err
+}
+
+// checks if the subject can use something with a schedule now, anyone matching one of the roles can use it at any time
+func isWithinSchedule(subject permissionSubject, sc schedule, roles []string) bool {
+	if sc.isOpen(time.Now()) {
+		return true
+	}
+
+	for _, rule := range roles {
+		if !isRuleValid(subject.guildID, rule) {
+			logger.Error("Invalid role in scheduleroles", "role", rule)
+			continue
+		}
+		if matched, _ := checkRule(subject, rule); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checks if a command is available now, failing closed when its schedule is invalid
+func isCommandAvailable(subject permissionSubject, cmdkey string) (schedule, bool, error) {
+	sc, scheduled, err := commandSchedule(subject.guildID, cmdkey)
+	if err != nil || !scheduled {
+		return sc, !scheduled, err
+	}
+
+	return sc, isWithinSchedule(subject, sc, viper.GetStringSlice(inheritedKey(cmdkey, "scheduleroles"))), nil
+}
+
+// checks if a tracked reaction is available now, failing closed when its schedule is invalid
+func isReactionAvailable(subject permissionSubject, reaction map[string]interface{}) bool {
+	value, scheduled := mapValue(reaction, "schedule")
+	if !scheduled {
+		return true
+	}
+
+	sc, err := parseSchedule(subject.guildID, value)
+	if err != nil {
+		reactionLog.Error("Invalid reaction schedule", "error", err)
+		return false
+	}
+
+	roles, _ := mapValue(reaction, "scheduleroles")
+
+	return isWithinSchedule(subject, sc, stringList(roles))
+}
+
+// identifies a users reaction on a message
+func reactionKey(messageID string, emoji string, userID string) string {
+	return messageID + "/" + emoji + "/" + userID
+}
+
+// remembers that the bot is removing a users reaction
+func markBotRemovedReaction(key string) {
+	botRemovedMutex.Lock()
+	defer botRemovedMutex.Unlock()
+
+	// forget removals whose event never arrived
+	for k, removed := range botRemovedReactions {
+		if time.Since(removed) > time.Minute {
+			delete(botRemovedReactions, k)
+		}
+	}
+
+	botRemovedReactions[key] = time.Now()
+}
+
+// checks if a removed reaction was removed by the bot, forgetting it
+func wasBotRemovedReaction(key string) bool {
+	botRemovedMutex.Lock()
+	defer botRemovedMutex.Unlock()
+
+	removed, ok := botRemovedReactions[key]
+	delete(botRemovedReactions, key)
+
+	return ok && time.Since(removed) <= time.Minute
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const schedulesConfig = `
+schedules:
+  opening:
+    timezone: "Europe/London"
+    windows:
+      - days: [mon, tue, wed, thu, fri]
+        from: "09:00"
+        to: "17:30"
+      - days: [Sat]
+        from: "10:00"
+        to: "02:00"
+    holidays:
+      - "2026-12-24"
+      - "01-01"
+commands:
+  named:
+    schedule: opening
+  inline:
+    schedule:
+      windows:
+        - from: "12:00"
+          to: "13:00"
+  group:
+    schedule: opening
+    subcommands:
+      child:
+        message: "x"
+`
+
+func TestScheduleIsOpen(t *testing.T) {
+	useConfig(t, schedulesConfig)
+
+	sc, scheduled, err := commandSchedule("", "commands.named")
+	if err != nil || !scheduled {
+		t.Fatalf("commandSchedule() = %v %v", scheduled, err)
+	}
+
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no timezone database")
+	}
+
+	tests := []struct {
+		at   string
+		open bool
+	}{
+		{"2026-10-19 08:59", false}, // monday
+		{"2026-10-19 09:00", true},
+		{"2026-10-19 17:29", true},
+		{"2026-10-19 17:30", false},
+		{"2026-10-17 09:59", false}, // saturday
+		{"2026-10-17 10:00", true},
+		{"2026-10-17 23:59", true},
+		{"2026-10-18 00:00", true}, // sunday, still in the saturday window
+		{"2026-10-18 01:59", true},
+		{"2026-10-18 02:00", false},
+		{"2026-10-19 01:00", false}, // monday, the sunday before has no window
+		{"2026-12-24 12:00", false}, // holiday on a thursday
+		{"2027-01-01 12:00", false}, // yearly holiday on a friday
+	}
+
+	for _, test := range tests {
+		at, err := time.ParseInLocation("2006-01-02 15:04", test.at, london)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if open := sc.isOpen(at); open != test.open {
+			t.Errorf("isOpen(%s %s) = %v, want %v", test.at, at.Weekday(), open, test.open)
+		}
+	}
+
+	// the time is converted to the timezone of the schedule
+	utc := time.Date(2026, 10, 19, 16, 45, 0, 0, time.UTC)
+	if sc.isOpen(utc) {
+		t.Errorf("isOpen(%s) = true, 17:45 in London is after closing", utc)
+	}
+}
+
+func TestCommandSchedule(t *testing.T) {
+	useConfig(t, schedulesConfig)
+
+	tests := []struct {
+		cmdkey    string
+		scheduled bool
+		windows   int
+	}{
+		{"commands.named", true, 2},
+		{"commands.inline", true, 1},
+		{"commands.group.subcommands.child", true, 2},
+		{"commands.missing", false, 0},
+	}
+
+	for _, test := range tests {
+		sc, scheduled, err := commandSchedule("", test.cmdkey)
+		if err != nil {
+			t.Errorf("commandSchedule(%s) error %v", test.cmdkey, err)
+			continue
+		}
+		if scheduled != test.scheduled || len(sc.windows) != test.windows {
+			t.Errorf("commandSchedule(%s) = %v with %d windows, want %v with %d", test.cmdkey, scheduled, len(sc.windows), test.scheduled, test.windows)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	useConfig(t, schedulesConfig)
+
+	tests := []struct {
+		name     string
+		schedule interface{}
+		err      string
+	}{
+		{"unknown name", "closed", "unknown schedule"},
+		{"bad timezone", map[string]interface{}{"timezone": "Nowhere/Special"}, "invalid timezone"},
+		{"bad day", map[string]interface{}{"windows": []interface{}{map[string]interface{}{"days": []interface{}{"funday"}}}}, "invalid day"},
+		{"bad time", map[string]interface{}{"windows": []interface{}{map[string]interface{}{"from": "25:00"}}}, "invalid time"},
+		{"bad minutes", map[string]interface{}{"windows": []interface{}{map[string]interface{}{"to": "12:60"}}}, "invalid time"},
+		{"bad holiday", map[string]interface{}{"holidays": []interface{}{"christmas"}}, "invalid holiday"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseSchedule("", test.schedule)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseSchedule() error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		value   string
+		minutes int
+		valid   bool
+	}{
+		{"00:00", 0, true},
+		{"09:30", 570, true},
+		{"24:00", 1440, true},
+		{"24:01", 0, false},
+		{"9", 0, false},
+		{"ab:cd", 0, false},
+	}
+
+	for _, test := range tests {
+		minutes, err := parseTimeOfDay(test.value)
+		if (err == nil) != test.valid || (test.valid && minutes != test.minutes) {
+			t.Errorf("parseTimeOfDay(%q) = %d %v", test.value, minutes, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// shown in place of secret values by --displayconfig
+const maskedText = "********"
+
+// settings that always hold secrets, more can be added with secretkeys
+var secretKeys = []string{"discordtoken", "homeassistanttoken", "cameraapiurl", "camerasnapshoturl", "canaryurl", "opswebhook"}
+
+// parts of setting names that mark them as holding secrets
+var secretSettingNames = []string{"token", "secret", "password", "apikey", "webhook"}
+
+// checks if a setting holds a secret, by name or because it is listed in secretkeys
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, secret := range append(secretKeys, viper.GetStringSlice("secretkeys")...) {
+		if key == strings.ToLower(secret) {
+			return true
+		}
+	}
+
+	// canary urls carry the id of the check
+	if strings.HasPrefix(key, "canaries.") && strings.HasSuffix(key, ".url") {
+		return true
+	}
+
+	name := key[strings.LastIndex(key, ".")+1:]
+	for _, secret := range secretSettingNames {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns every secret setting, including those only set from the environment
+func secretSettings() []string {
+	found := make(map[string]bool)
+	for _, key := range append(viper.AllKeys(), secretKeys...) {
+		if isSecretKey(key) {
+			found[key] = true
+		}
+	}
+
+	var keys []string
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// lets SDB_DISCORDTOKEN etc override settings, the prefix can be changed with envprefix
+func setupEnvOverrides() {
+	viper.SetEnvPrefix(envPrefix())
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+}
+
+// returns the prefix of environment variables that override settings
+func envPrefix() string {
+	if viper.IsSet("envprefix") {
+		return viper.GetString("envprefix")
+	}
+	return "SDB"
+}
+
+// replaces env:NAME and file:/path references in secret settings with the value they point to
+func resolveSecrets() error {
+	var problems []string
+
+	for _, key := range secretSettings() {
+		value, ok := viper.Get(key).(string)
+		if !ok {
+			continue
+		}
+
+		resolved, err := resolveSecret(value)
+		if err != nil {
+			problems = append(problems, key+": "+err.Error())
+			continue
+		}
+
+		if resolved != value {
+			viper.Set(key, resolved)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// resolves a single secret reference, values that are not references are returned unchanged
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return resolved, nil
+
+	case strings.HasPrefix(value, "file:"):
+		// docker secrets end with a newline
+		contents, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	}
+
+	return value, nil
+}
+
+// returns a copy of a setting with any secrets in it masked
+func maskSecrets(key string, value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(typed))
+		for name, inner := range typed {
+			masked[name] = maskSecrets(key+"."+name, inner)
+		}
+		return masked
+	case string:
+		if typed != "" && isSecretKey(key) {
+			return maskedText
+		}
+	}
+	return value
+}
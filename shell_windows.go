@@ -0,0 +1,18 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+)
+
+// process groups are not supported, only the command itself is killed
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// kills the command
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// the outcome of running a shell command
+type shellResult struct {
+	ExitCode  int
+	Stdout    string
+	Stderr    string
+	Duration  time.Duration
+	Truncated bool
+	// set when the command could not be run or was killed, a non zero exit code is not an error
+	Err error
+}
+
+// returns the exit code of a finished command, -1 when it did not exit normally
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+// options used when templating the response of a shell command
+func (r shellResult) options() map[string]string {
+	return map[string]string{
+		"{exitcode}": strconv.Itoa(r.ExitCode),
+		"{stdout}":   strings.TrimRight(r.Stdout, "\n"),
+		"{stderr}":   strings.TrimRight(r.Stderr, "\n"),
+		"{duration}": r.Duration.Round(10 * time.Millisecond).String(),
+	}
+}
+
+// returns the template set for the result of a shell command, success or failure depending on the exit code, falling back to output
+func shellTemplate(cmdkey string, result shellResult) (string, bool) {
+	if result.ExitCode == 0 && viper.IsSet(cmdkey+".success") {
+		return viper.GetString(cmdkey + ".success"), true
+	}
+	if result.ExitCode != 0 && viper.IsSet(cmdkey+".failure") {
+		return viper.GetString(cmdkey + ".failure"), true
+	}
+	if viper.IsSet(cmdkey + ".output") {
+		return viper.GetString(cmdkey + ".output"), true
+	}
+	return "", false
+}
+
+// builds the response to a shell command, returns true if the response is the raw output that should be shown as code
+func shellResponse(cmdkey string, result shellResult, commandoptions map[string]string) (string, bool) {
+	hidestderr := viper.GetBool(cmdkey + ".hidestderr")
+	if hidestderr {
+		result.Stderr = ""
+	}
+
+	if template, ok := shellTemplate(cmdkey, result); ok {
+		// user options and result placeholders are replaced in one pass so arguments or output containing placeholders are left alone
+		return templateReplacer(commandoptions, result.options()).Replace(template), false
+	}
+
+	response := result.Stdout
+	if len(result.Stderr) > 0 {
+		response = response + "\nSTDERR:\n-------\n" + result.Stderr
+	}
+
+	if strings.TrimSpace(response) == "" {
+		response = "(no output)"
+	}
+
+	if result.ExitCode != 0 {
+		response = response + "\nExit code: " + strconv.Itoa(result.ExitCode)
+	}
+
+	return response, true
+}
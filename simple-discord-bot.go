@@ -2,11 +2,11 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
@@ -30,9 +30,16 @@ var (
 	Token string
 )
 
-func init() {
+// reads the flags and config, run from main rather than init so the package can be tested
+func loadConfig() {
+	// the bot re-executes itself to apply sandbox limits before running a shell command
+	if len(os.Args) > 1 && os.Args[1] == sandboxArg {
+		runSandboxHelper(os.Args[2:])
+	}
+
 	flag.String("config", "config.yaml", "Configuration file: /path/to/file.yaml, default = ./config.yaml")
 	flag.Bool("displayconfig", false, "Display configuration")
+	flag.Bool("showsecrets", false, "Display secrets with the configuration")
 	flag.Bool("help", false, "Display help")
 	flag.Bool("version", false, "Display version")
 	flag.Int("chunksize", 1980, "Message chunk size, default = 1980")
@@ -69,22 +76,42 @@ func init() {
 
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			log.Fatal("Config file not found")
+			logger.Error("Config file not found")
 		} else {
-			log.Fatal("Config file was found but another error was discovered: ", err)
+			logger.Error("Config file was found but another error was discovered", "error", err)
 		}
+		os.Exit(1)
+	}
+
+	// merge in included files and the conf.d directory
+	if err := loadIncludes(configdir); err != nil {
+		logger.Error("Cannot load included config", "error", err)
+		os.Exit(1)
+	}
+
+	// settings can be overridden from the environment, and secrets loaded from the environment or files
+	setupEnvOverrides()
+	if err := resolveSecrets(); err != nil {
+		logger.Error("Cannot load secrets", "error", err)
+		os.Exit(1)
 	}
 
 	if !viper.IsSet("discordtoken") {
-		log.Fatal("No discordtoken configured")
+		logger.Error("No discordtoken configured")
+		os.Exit(1)
 	}
 
 	Token = viper.GetString("discordtoken")
 
+	// the token is known now, so it can be redacted from the logs
+	setupLogging()
+
 	// listRoles()
 }
 
 func main() {
+	loadConfig()
+
 	if viper.GetBool("displayconfig") {
 		displayConfig()
 		os.Exit(0)
@@ -93,32 +120,56 @@ func main() {
 	// Create a new Discord session using the provided bot token.
 	dg, err := discordgo.New("Bot " + Token)
 	if err != nil {
-		log.Println("error creating Discord session,", err)
+		logger.Error("Cannot create discord session", "error", err)
 		return
 	}
 
+	// register external plugins alongside the built in functions
+	registerPlugins()
+
+	// workers that commands run on
+	setupWorkers()
+
+	openAuditLog()
+
 	dg.AddHandler(messageCreate)
 
 	dg.AddHandler(addReaction)
 
 	dg.AddHandler(removeReaction)
 
-	err = dg.Open()
+	dg.AddHandler(helpInteraction)
+
+	addGatewayHandlers(dg)
+
+	// keep channels, members and roles cached from gateway events
+	setupIntents(dg)
+	addCacheHandlers(dg)
+
+	// retry connecting so a discord outage while starting does not stop the bot
+	err = openSession(dg)
 	if err != nil {
-		log.Println("error opening connection,", err)
+		logger.Error("Cannot open connection to discord", "error", err)
 		return
 	}
 
 	if viper.GetBool("canaryenable") {
-		go canaryCheckin(viper.GetString("canaryurl"), viper.GetInt("canaryinterval"))
+		startCanaries(dg)
 	}
 
 	if viper.GetBool("shellenable") && !viper.IsSet("shell") {
-		log.Println("Error: If shellenable=true, a shell must be defined")
+		logger.Error("If shellenable=true, a shell must be defined")
 		os.Exit(1)
 	}
 
-	log.Printf("simple-discord-bot %s is now running.  Press CTRL-C to exit.\n", applicationVersion)
+	logger.Info("simple-discord-bot is now running.  Press CTRL-C to exit.", "version", applicationVersion)
+
+	// forward warnings and errors to ops, and let ops know the bot started
+	startOpsForwarding(dg)
+	opsNotice(dg, "simple-discord-bot "+applicationVersion+" started")
+
+	// find the ids of roles given by name
+	resolveAllRoleNames(dg)
 
 	// check tracked reactions
 	checkReactions(dg)
@@ -127,11 +178,32 @@ func main() {
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
 
+	// stop accepting commands and give running commands time to finish
+	shutdowntimeout := 30 * time.Second
+	if viper.IsSet("shutdowntimeout") {
+		shutdowntimeout = getDuration("shutdowntimeout")
+	}
+
+	logger.Info("Shutting down, waiting for running commands to finish")
+	if !commandWorkers.shutdown(shutdowntimeout) {
+		logger.Error("Commands still running, shutting down anyway", "timeout", shutdowntimeout)
+	}
+
+	flushAuditLog()
+
+	// send anything still queued for ops before saying goodbye
+	flushOps(dg)
+	opsNotice(dg, "simple-discord-bot "+applicationVersion+" shutting down")
+
 	dg.Close()
 }
 
-// displays configuration
+// displays configuration, secrets are masked unless --showsecrets is given
 func displayConfig() {
+	for _, file := range configFiles {
+		fmt.Println("CONFIG FILE:", file)
+	}
+
 	allmysettings := viper.AllSettings()
 	var keys []string
 	for k := range allmysettings {
@@ -139,7 +211,11 @@ func displayConfig() {
 	}
 	sort.Strings(keys)
 	for _, k := range keys {
-		fmt.Println("CONFIG:", k, ":", allmysettings[k])
+		value := allmysettings[k]
+		if !viper.GetBool("showsecrets") {
+			value = maskSecrets(k, value)
+		}
+		fmt.Println("CONFIG:", k, ":", value)
 	}
 }
 
@@ -150,6 +226,7 @@ func displayHelp() {
       --config string       Configuration file: /path/to/file.yaml (default "./config.yaml")
       --displayconfig       Display configuration
       --help                Display help
+      --showsecrets         Display secrets with the configuration, they are masked by default
       --splitchar string    Character to split chunks on, default = \n
       --version             Display version
 `
@@ -165,194 +242,357 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
-	chanl, err := s.Channel(m.ChannelID)
+	chanl, err := cachedChannel(s, m.ChannelID)
 	if err != nil {
 		return
 	}
 
-	guild, _ := s.Guild(chanl.GuildID)
-	var author *discordgo.Member
-
-	if guild != nil {
-		author, _ = s.GuildMember(guild.ID, m.Author.ID)
-	} else {
-		author, _ = s.GuildMember(viper.GetString("defaultserverid"), m.Author.ID)
-	}
+	// direct messages resolve to the home guild
+	guildID := resolveGuildID(chanl.GuildID)
 
-	// ignore commands we don't care about
-	if !strings.HasPrefix(strings.ToLower(m.Content), strings.ToLower(viper.GetString("commandkey"))+" ") {
+	// strip out the command prefix, ignoring messages that are not commands
+	cleancommand, iscommand := stripPrefix(s, guildID, m.Content, chanl.GuildID == "")
+	if !iscommand {
 		return
 	}
 
-	// log commands passed to bot
-	log.Printf("User:%s ID:%s Command:\"%s\"\n", m.Author.Username, m.Author.ID, m.Content)
+	author, _ := cachedMember(s, guildID, m.Author.ID)
+	subject := messageSubject(s, m, guildID, author)
 
-	// strip out the command key
-	cleancommand := strings.Replace(strings.ToLower(m.Content), viper.GetString("commandkey")+" ", "", 1)
+	// primary command key, used when showing commands to the user
+	commandkey := viper.GetString(guildKey(guildID, "commandkey"))
 
-	// mycommand = the valid command found
+	// request = the command found and its arguments
 	// iscommandvalid = is command valid?
-	// commandoptions = map of all options, ready for templating
-	mycommand, iscommandvalid, commandoptions := findCommand(cleancommand)
+	request, iscommandvalid := findCommand(guildID, cleancommand)
+
+	// log commands passed to bot, leaving out the arguments of secret commands
+	logtext := m.Content
+	if iscommandvalid && viper.GetBool(request.Key+".secret") {
+		logtext = request.Command + " " + redactedText
+	}
+	userLog := commandLog.With("user", m.Author.Username, "userid", m.Author.ID, "command", logtext)
+	userLog.Info("Command received")
 
 	if !iscommandvalid {
-		log.Printf("User:%s ID:%s Command:\"%s\" Status:\"Command is invalid\"\n", m.Author.Username, m.Author.ID, m.Content)
+		userLog.Info("Command is invalid")
+		sendReply(s, m, guildID, "", replyUnknownCommand, map[string]string{
+			"{command}":     escapeUserText(cleancommand),
+			"{suggestions}": formatSuggestions(commandkey, suggestCommands(subject, strings.ToLower(cleancommand))),
+		})
 		return
 	}
 
-	// find role for the primary command
-	commandRoles := viper.GetStringSlice("commands." + mycommand + ".roles")
+	mycommand := request.Command
 
-	// check if a role has been assigned to the command, and ignore if none has been set or role is invalid
-	for _, role := range commandRoles {
-		if !isRoleValid(role) {
-			// role doesn't exist
-			log.Printf("Error: role (%s) not valid do not exist for command %s", role, mycommand)
-			return
+	// options used when templating replies to the user
+	replyoptions := map[string]string{"{command}": mycommand}
+
+	// viper key holding the commands configuration
+	cmdkey := request.Key
+
+	// check if the command is allowed in this channel, thread or dm
+	if !isCommandLocationAllowed(guildID, cmdkey, resolveChannelLocation(s, chanl)) {
+		userLog.Info("Command not allowed in channel", "channel", m.ChannelID)
+		if deniedmessage := channelDeniedMessage(guildID, cmdkey); deniedmessage != "" {
+			channelMessageCreate(s, m, deniedmessage, false)
 		}
+		return
+	}
+
+	// check the roles and deny rules of the command can be checked, subcommands inherit them from their group
+	if rule, invalid := invalidRule(guildID, cmdkey); invalid {
+		// role doesn't exist
+		userLog.Error("Role does not exist", "role", rule)
+		sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
+		return
 	}
 
 	// check if user has permission to execute a command
-	var canRun bool = false
-	for _, role := range commandRoles {
-		if checkUserPerms(role, author, m.Author.ID) {
-			canRun = true
-		}
+	if !canRunCommand(subject, cmdkey) {
+		userLog.Warn("User does not have permission to run command")
+		sendReply(s, m, guildID, cmdkey, replyPermissionDenied, replyoptions)
+		return
+	}
+
+	// check the command is available at this time, some roles can run it outside its schedule
+	sc, available, err := isCommandAvailable(subject, cmdkey)
+	if err != nil {
+		userLog.Error("Invalid schedule", "error", err)
+		sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
+		return
 	}
-	if !canRun {
-		log.Printf("Error: User:%s ID:%s Does not have permission to run Command: \"%s\"\n", m.Author.Username, m.Author.ID, m.Content)
+	if !available {
+		userLog.Info("Command not available at this time")
+		replyoptions["{schedule}"] = sc.describe()
+		sendReply(s, m, guildID, cmdkey, replyUnavailable, replyoptions)
 		return
 	}
 
-	// check if command is valid and do appropriate text response
-	if _, ok := viper.GetStringMap("commands")[mycommand]; ok {
+	// check the user has given enough arguments for the command
+	if len(request.Args) < requiredArguments(cmdkey) {
+		userLog.Info("Missing arguments")
+		replyoptions["{usage}"] = commandUsage(cmdkey, commandkey, mycommand)
+		sendReply(s, m, guildID, cmdkey, replyMissingArguments, replyoptions)
+		return
+	}
 
-		ismessage := viper.IsSet("commands." + mycommand + ".message")
-		isapicall := viper.IsSet("commands." + mycommand + ".api")
-		isfile := viper.IsSet("commands." + mycommand + ".file")
-		isshell := viper.IsSet("commands." + mycommand + ".shell")
-		isfunction := viper.IsSet("commands." + mycommand + ".function")
-		issecret := viper.GetBool("commands." + mycommand + ".secret")
+	// groups without an action of their own list their subcommands, which does not use up the cooldown
+	if isCommandGroup(cmdkey) {
+		showGroupHelp(s, m, guildID, mycommand, cmdkey, commandkey, author)
+		return
+	}
 
-		// if api and file then return and throw an error, this is not a valid option configuration
-		if isapicall && isfile {
-			log.Printf("Error: Cannot have command api with file on command %s\n", mycommand)
-			return
+	// check the command is not cooling down for this user
+	if remaining := checkCooldown(cmdkey, m.Author.ID); remaining > 0 {
+		userLog.Info("Cooling down", "remaining", remaining)
+		replyoptions["{remaining}"] = remaining.Round(time.Second).String()
+		sendReply(s, m, guildID, cmdkey, replyCooldown, replyoptions)
+		return
+	}
+
+	// run the command on the worker pool, commands from the same user run one after another
+	if err := commandWorkers.submit(m.Author.ID, func() { executeCommand(s, m, request) }); err != nil {
+		userLog.Warn("Command not queued", "error", err)
+		releaseCooldown(cmdkey, m.Author.ID)
+		if err == errQueueFull {
+			sendReply(s, m, guildID, cmdkey, replyBusy, replyoptions)
 		}
+	}
+}
+
+// runs the action of a command and sends the response
+func executeCommand(s *discordgo.Session, m *discordgo.MessageCreate, request commandRequest) {
+	guildID := request.GuildID
+	mycommand := request.Command
+	cmdkey := request.Key
+
+	// map of all options, ready for templating
+	commandoptions := request.options()
+
+	// options used when templating replies to the user
+	replyoptions := map[string]string{"{command}": mycommand}
+
+	cmdLog := commandLog.With("user", m.Author.Username, "userid", m.Author.ID, "command", mycommand)
+
+	// record the outcome of the command in the audit log
+	status := "success"
+	started := time.Now()
+	defer func() {
+		auditCommand(m, request, status)
+		cmdLog.Debug("Command finished", "status", status, "duration", time.Since(started))
+	}()
+
+	// let the user know the bot is working on commands that take a while
+	stopTyping := startTyping(s, m.ChannelID)
+	defer stopTyping()
 
-		// if shell and (file or api) then return and throw an error, this is not a valid option configuration
-		if isshell && (isfile || isapicall) {
-			log.Printf("Error: Cannot have command shell with file or api on command %s\n", mycommand)
+	var err error
+
+	ismessage := viper.IsSet(cmdkey + ".message")
+	isapicall := viper.IsSet(cmdkey + ".api")
+	isfile := viper.IsSet(cmdkey + ".file")
+	isshell := viper.IsSet(cmdkey + ".shell")
+	isfunction := viper.IsSet(cmdkey + ".function")
+	issteps := viper.IsSet(cmdkey + ".steps")
+	issecret := viper.GetBool(cmdkey + ".secret")
+
+	// if api and file then return and throw an error, this is not a valid option configuration
+	if isapicall && isfile {
+		cmdLog.Error("Cannot have command api with file")
+		status = "error"
+		sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
+		return
+	}
+
+	// if shell and (file or api) then return and throw an error, this is not a valid option configuration
+	if isshell && (isfile || isapicall) {
+		cmdLog.Error("Cannot have command shell with file or api")
+		status = "error"
+		sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
+		return
+	}
+
+	// if function and (file or api or shell) then return and throw an error, this is not a valid option configuration
+	if isfunction && (isshell || isfile || isapicall) {
+		cmdLog.Error("Cannot have command function with shell or file or api")
+		status = "error"
+		sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
+		return
+	}
+
+	// steps run their own actions, so cannot be combined with any other
+	if issteps && (ismessage || isapicall || isfile || isshell || isfunction) {
+		cmdLog.Error("Cannot have command steps with message, api, file, shell or function")
+		status = "error"
+		sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
+		return
+	}
+
+	// every action gets a context that ends when the commands timeout is reached
+	timeout := commandTimeout(guildID, cmdkey)
+	replyoptions["{timeout}"] = timeout.String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var messagetosend string
+
+	// raw shell output is shown as code, templated shell responses are sent as written
+	shellcodeblock := true
+
+	if ismessage {
+		messagetosend = prepareTemplate(viper.GetString(cmdkey+".message"), commandoptions)
+	} else if isapicall {
+		// if an api call do it and get response which will become the message sent to the user
+		messagetosend, err = downloadApi(ctx, prepareTemplate(viper.GetString(cmdkey+".api"), commandoptions))
+		if err != nil {
+			status = sendFailureReply(ctx, s, m, guildID, cmdkey, replyoptions)
 			return
 		}
 
-		// if function and (file or api or shell) then return and throw an error, this is not a valid option configuration
-		if isfunction && (isshell || isfile || isapicall) {
-			log.Printf("Error: Cannot have command function with shell or file or api on command %s\n", mycommand)
+	} else if isfile {
+		// if we need to load a files contents into message to send
+		tempcontents, err := loadFile(prepareTemplate(viper.GetString(cmdkey+".file"), commandoptions))
+		if err != nil {
+			cmdLog.Error("Cannot load file", "file", viper.GetString(cmdkey+".file"), "error", err)
+			status = "error"
+			sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
 			return
 		}
 
-		var messagetosend string
+		messagetosend = tempcontents
+	} else if isshell && viper.GetBool("shellenable") && viper.GetBool(cmdkey+".stream") {
+		// stream the output into a message that is edited as the command runs
+		stopTyping()
 
-		if ismessage {
-			messagetosend = prepareTemplate(viper.GetString("commands."+mycommand+".message"), commandoptions)
-		} else if isapicall {
-			// if an api call do it and get response which will become the message sent to the user
-			messagetosend = downloadApi(prepareTemplate(viper.GetString("commands."+mycommand+".api"), commandoptions))
-
-		} else if isfile {
-			// if we need to load a files contents into message to send
-			tempcontents, err := loadFile(prepareTemplate(viper.GetString("commands."+mycommand+".file"), commandoptions))
+		channelID := m.ChannelID
+		if issecret {
+			channel, err := s.UserChannelCreate(m.Author.ID)
 			if err != nil {
-				log.Printf("Error loading file: %s with: %v\n", messagetosend, err)
+				cmdLog.Error("Cannot create PM channel", "error", err)
+				status = "error"
 				return
 			}
+			channelID = channel.ID
+		}
 
-			messagetosend = tempcontents
-		} else if isshell && viper.GetBool("shellenable") {
-			err, stdout, stderr := shellOut(prepareTemplate(viper.GetString("commands."+mycommand+".shell"), commandoptions))
-			if err != nil {
-				log.Printf("Error: Error executing command:\"%s\" err:%v\n", messagetosend, err)
-			}
+		exitcode, err := streamShell(ctx, s, channelID, cmdkey, prepareTemplate(viper.GetString(cmdkey+".shell"), commandoptions), commandoptions)
+		if ctx.Err() != nil {
+			status = sendFailureReply(ctx, s, m, guildID, cmdkey, replyoptions)
+		} else if err != nil {
+			cmdLog.Error("Cannot run command", "error", err)
+			status = "error"
+			sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
+		} else if exitcode != 0 {
+			status = "exit code " + strconv.Itoa(exitcode)
+		}
 
-			messagetosend = ""
-			if len(stdout) > 0 {
-				messagetosend = messagetosend + stdout
-			}
-			if len(stderr) > 0 {
-				messagetosend = messagetosend + "\nSTDERR:\n-------\n" + stderr
-			}
-			//messagetosend = messagetosend + "```\n"
+		return
+	} else if isshell && viper.GetBool("shellenable") {
+		result := shellOut(ctx, cmdkey, prepareTemplate(viper.GetString(cmdkey+".shell"), commandoptions))
 
-			// if messagetosend is empty, do nothing and return
-			if len(messagetosend) == 8 {
-				return
-			}
-		} else if isshell && !viper.GetBool("shellenable") {
-			// do nothing and return when command is a shell and shellenable = false
-			log.Println("Error: Cannot run shell command when shellenable = false")
+		// the command was killed, so its output is incomplete
+		if ctx.Err() != nil {
+			status = sendFailureReply(ctx, s, m, guildID, cmdkey, replyoptions)
 			return
-		} else if isfunction {
-			lengthOfMessageWithoutCommand := len(viper.GetString("commandkey")) + 1 + len(mycommand) + 1
-			var message string
-			if lengthOfMessageWithoutCommand > len(m.Content) {
-				message = ""
-			} else {
-				message = m.Content[lengthOfMessageWithoutCommand:]
-			}
+		}
 
-			functionName := prepareTemplate(viper.GetString("commands."+mycommand+".function"), commandoptions)
-			// Map function names to actual functions
-			functions := map[string]func(*discordgo.Session, *discordgo.MessageCreate, string, string){
-				"sendMessage":      sendMessage,
-				"editMessage":      editMessage,
-				"listEmoji":        listEmoji,
-				"showHelp":         showHelp,
-				"apiHomeAssistant": apiHomeAssistant,
-				"cameraSnapshot":   cameraSnapshot,
-				"cameraList":       cameraList,
-			}
+		// the command could not be run at all
+		if result.Err != nil {
+			cmdLog.Error("Cannot run command", "error", result.Err)
+			status = "error"
+			sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
+			return
+		}
 
-			// Call the function based on the name
-			if function, ok := functions[functionName]; ok {
-				function(s, m, mycommand, message)
-			} else {
-				fmt.Println("Function", functionName, "not found")
-			}
+		if result.ExitCode != 0 {
+			status = "exit code " + strconv.Itoa(result.ExitCode)
+		}
 
+		messagetosend, shellcodeblock = shellResponse(cmdkey, result, commandoptions)
+	} else if isshell && !viper.GetBool("shellenable") {
+		// do nothing and return when command is a shell and shellenable = false
+		cmdLog.Error("Cannot run shell command when shellenable = false")
+		status = "error"
+		sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
+		return
+	} else if issteps {
+		// each step sends its own messages, then a summary is sent
+		status = runSteps(ctx, s, m, request, replyoptions)
+		return
+	} else if isfunction {
+		functionName := prepareTemplate(viper.GetString(cmdkey+".function"), commandoptions)
+
+		// Call the registered function based on the name
+		if function, ok := functions[functionName]; ok {
+			err := function.Execute(ctx, functionRequest{Session: s, Message: m, commandRequest: request})
+			if err != nil {
+				cmdLog.Error("Function failed", "function", functionName, "error", err)
+				status = sendFailureReply(ctx, s, m, guildID, cmdkey, replyoptions)
+			}
+		} else {
+			cmdLog.Error("Function not found", "function", functionName)
+			status = "error"
+			sendReply(s, m, guildID, cmdkey, replyError, replyoptions)
 		}
 
-		var usewrapper = false
+	}
+
+	var usewrapper = false
+
+	if (isshell && shellcodeblock) || isfile {
+		usewrapper = true
+	}
 
-		if isshell || isfile {
-			usewrapper = true
+	// send the command response, if marked as secret send via private message do not send if command is a custom function
+	if !isfunction {
+		if issecret {
+			privateMessageCreate(s, m.Author.ID, messagetosend, usewrapper)
+		} else {
+			channelMessageCreate(s, m, messagetosend, usewrapper)
 		}
+	}
+}
 
-		// send the command response, if marked as secret send via private message do not send if command is a custom function
-		if !isfunction {
-			if issecret {
-				privateMessageCreate(s, m.Author.ID, messagetosend, usewrapper)
-			} else {
-				channelMessageCreate(s, m, messagetosend, usewrapper)
-			}
+// returns a replacer for the placeholders of several option sets, later sets win. everything is replaced in a
+// single pass, so values containing placeholders are never expanded again
+func templateReplacer(optionsets ...map[string]string) *strings.Replacer {
+	merged := make(map[string]string)
+	for _, options := range optionsets {
+		for key, value := range options {
+			merged[key] = value
 		}
+	}
 
-		return
+	// longest first so {previous.output} wins over any shorter placeholder starting the same way
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	var replacements []string
+	for _, key := range keys {
+		replacements = append(replacements, key, merged[key])
 	}
+
+	return strings.NewReplacer(replacements...)
 }
 
 func prepareTemplate(message string, commandoptions map[string]string) string {
 	// do all the templating, replace {0} etc in the command with the options the user has given
-	for key, value := range commandoptions {
-		message = strings.Replace(message, key, value, -1)
-	}
-
-	return message
+	return templateReplacer(commandoptions).Replace(message)
 }
 
 // discord addReaction handler
 func addReaction(s *discordgo.Session, mr *discordgo.MessageReactionAdd) {
-	for _, v := range viper.GetStringMap("reactions") {
+	for _, v := range guildReactions(mr.GuildID) {
 		if m, ok := v.(map[string]interface{}); ok {
 			// check message id is being tracked
 			if strconv.Itoa(m["message_id"].(int)) == mr.MessageID {
@@ -360,22 +600,38 @@ func addReaction(s *discordgo.Session, mr *discordgo.MessageReactionAdd) {
 				// check emoji is being tracked for this message
 				emoji := strings.Split(m["emoji"].(string), ":")
 				if emoji[0] == mr.Emoji.Name {
+					// outside its schedule the reaction is taken away again, so it matches the roles people hold
+					subject := permissionSubject{session: s, guildID: mr.GuildID, channelID: mr.ChannelID, member: mr.Member, userID: mr.UserID}
+					if !isReactionAvailable(subject, m) {
+						reactionLog.Info("Reaction not available at this time", "userid", mr.UserID, "message", mr.MessageID)
+						markBotRemovedReaction(reactionKey(mr.MessageID, mr.Emoji.Name, mr.UserID))
+						s.MessageReactionRemove(mr.ChannelID, mr.MessageID, mr.Emoji.APIName(), mr.UserID)
+						continue
+					}
+
 					// check which type of reaction this is
 					if m["type"] == "role" {
 						// add role
 						s.GuildMemberRoleAdd(mr.GuildID, mr.UserID, strconv.Itoa(m["role_id"].(int)))
+						reactionLog.Info("Role added", "userid", mr.UserID, "role", m["role_id"])
 					}
 				}
 			}
 		} else {
-			fmt.Println("Data is not a map[string]interface{}")
+			reactionLog.Error("Reaction is not a map")
 		}
 	}
 }
 
 // discord removeReaction handler
 func removeReaction(s *discordgo.Session, mr *discordgo.MessageReactionRemove) {
-	for _, v := range viper.GetStringMap("reactions") {
+	// the bot removed this reaction because it was outside its schedule, any role the user holds is still theirs
+	if wasBotRemovedReaction(reactionKey(mr.MessageID, mr.Emoji.Name, mr.UserID)) {
+		reactionLog.Debug("Ignoring reaction removed by the bot", "userid", mr.UserID, "message", mr.MessageID)
+		return
+	}
+
+	for _, v := range guildReactions(mr.GuildID) {
 		if m, ok := v.(map[string]interface{}); ok {
 			// check message id is being tracked
 			if strconv.Itoa(m["message_id"].(int)) == mr.MessageID {
@@ -387,392 +643,148 @@ func removeReaction(s *discordgo.Session, mr *discordgo.MessageReactionRemove) {
 					if m["type"] == "role" {
 						// remove role
 						s.GuildMemberRoleRemove(mr.GuildID, mr.UserID, strconv.Itoa(m["role_id"].(int)))
+						reactionLog.Info("Role removed", "userid", mr.UserID, "role", m["role_id"])
 					}
 				}
 			}
 		} else {
-			fmt.Println("Data is not a map[string]interface{}")
+			reactionLog.Error("Reaction is not a map")
 		}
 	}
 }
 
 // check reactions
 func checkReactions(s *discordgo.Session) {
-	fmt.Println("Checking reactions for tracked messages")
-	for _, v := range viper.GetStringMap("reactions") {
-		if m, ok := v.(map[string]interface{}); ok {
-			channelID := strconv.Itoa(m["channel_id"].(int))
-			messageID := strconv.Itoa(m["message_id"].(int))
-
-			// check emoji is being tracked for this message
-			messageReactions, err := s.MessageReactions(channelID, messageID, m["emoji"].(string), 100, "", "")
-			if err != nil {
-				log.Printf("Error: Checking reactions channelID:%s messageID:%s, Error:%s\n", channelID, messageID, err)
-			}
-			var hasBotReaction bool = false
-			for _, user := range messageReactions {
-				if user.ID == s.State.User.ID {
-					hasBotReaction = true
-				}
-			}
-
-			if !hasBotReaction {
-				s.MessageReactionAdd(channelID, messageID, m["emoji"].(string))
-				// pause to make sure reactions are added in order
-				time.Sleep(1 * time.Second)
-			}
+	reactionLog.Info("Checking reactions for tracked messages")
+	for _, m := range allReactions() {
+		channelID := strconv.Itoa(m["channel_id"].(int))
+		messageID := strconv.Itoa(m["message_id"].(int))
 
-		}
-	}
-
-}
-
-// custom command function for sending messages as the bot
-func sendMessage(s *discordgo.Session, m *discordgo.MessageCreate, command string, content string) {
-
-	// split the string by whitespace
-	words := strings.Split(content, " ")
-
-	// get channel ID
-	channelID := strings.Join(words[0:1], " ")
-
-	// Get the last words ignoring the first
-	message := strings.Join(words[1:], " ")
-
-	// send message to channel
-	s.ChannelMessageSend(channelID, message)
-}
-
-// custom command function for editing messages as the bot
-func editMessage(s *discordgo.Session, m *discordgo.MessageCreate, command string, content string) {
-
-	// split the string by whitespace
-	words := strings.Split(content, " ")
-
-	// get channel ID
-	channelID := strings.Join(words[0:1], " ")
-
-	// get message ID
-	messageID := strings.Join(words[1:2], " ")
-
-	// Get the last words ignoring the first two
-	message := strings.Join(words[2:], " ")
-
-	// edits message in channel
-	s.ChannelMessageEdit(channelID, messageID, message)
-}
-
-// custom command function to list all Emoji
-func listEmoji(s *discordgo.Session, m *discordgo.MessageCreate, command string, content string) {
-
-	words := strings.Split(content, " ")
-
-	// get guild ID from message
-	guildID := strings.Join(words[0:1], " ")
-
-	//	var guildID string = m.GuildID
-
-	if guildID == "" {
-		guildID = m.GuildID
-	}
-
-	if guildID != "" {
-		emojis, err := s.GuildEmojis(guildID)
+		// check emoji is being tracked for this message
+		messageReactions, err := s.MessageReactions(channelID, messageID, m["emoji"].(string), 100, "", "")
 		if err != nil {
-			log.Printf("Error: could not get emoji with error:%s", err)
+			reactionLog.Error("Cannot check reactions", "channel", channelID, "message", messageID, "error", err)
 		}
-
-		var message string
-
-		for _, emoji := range emojis {
-			if m.GuildID != "" {
-				message += "<:" + emoji.Name + ":" + emoji.ID + ">  `" + emoji.ID + "    " + emoji.Name + "`\n"
-			} else {
-				message += emoji.ID + "    " + emoji.Name + "\n"
+		var hasBotReaction bool = false
+		for _, user := range messageReactions {
+			if user.ID == s.State.User.ID {
+				hasBotReaction = true
 			}
 		}
 
-		if m.GuildID != "" {
-			channelMessageCreate(s, m, "**Emoji for "+guildID+"**\n"+message, false)
-		} else {
-			privateMessageCreate(s, m.Author.ID, "**Emoji for "+guildID+"**\n```"+message+"```", false)
-		}
-	} else {
-		if m.GuildID != "" {
-			channelMessageCreate(s, m, "Guild/Server ID not found", false)
-		} else {
-			privateMessageCreate(s, m.Author.ID, "Guild/Server ID not found", false)
+		if !hasBotReaction {
+			s.MessageReactionAdd(channelID, messageID, m["emoji"].(string))
+			// pause to make sure reactions are added in order
+			time.Sleep(1 * time.Second)
 		}
 	}
-}
 
-type SnapshotResponse struct {
-	EventID string `json:"event_id"`
 }
 
-// custom command function to take a camera snapshot
-func cameraSnapshot(s *discordgo.Session, m *discordgo.MessageCreate, command string, content string) {
-
-	words := strings.Split(content, " ")
-
-	// get camera from message
-	camera := strings.Join(words[0:1], " ")
-
-	if camera != "" {
-
-		// Define the API endpoint
-		url := viper.GetString("cameraapiurl") + "/api/events/" + camera + "/Discord Snapshot/create"
-
-		// Create a POST request
-		req, err := http.NewRequest("POST", url, nil)
-		if err != nil {
-			log.Printf("Error creating request: %v", err)
-			privateMessageCreate(s, m.Author.ID, fmt.Sprintf("Error creating request: %v", err), false)
+// gives the role of each tracked role reaction to the users who reacted while the bot could not see it
+// roles are never taken away here, people who removed their reaction while the bot was offline keep the role
+func reconcileReactionRoles(s *discordgo.Session) {
+	for _, m := range allReactions() {
+		if m["type"] != "role" {
+			continue
 		}
-		req.Header.Set("Content-Type", "application/json")
 
-		// Send the POST request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("Error sending POST request: %v", err)
-			privateMessageCreate(s, m.Author.ID, fmt.Sprintf("Error sending POST request: %v", err), false)
-		}
-		defer resp.Body.Close()
+		channelID := strconv.Itoa(m["channel_id"].(int))
+		messageID := strconv.Itoa(m["message_id"].(int))
+		roleID := strconv.Itoa(m["role_id"].(int))
 
-		// Check if the request was successful
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Request failed with status: %d", resp.StatusCode)
-			privateMessageCreate(s, m.Author.ID, fmt.Sprintf("Request failed with status: %d", resp.StatusCode), false)
-		}
-
-		// Read the response body
-		body, err := ioutil.ReadAll(resp.Body)
+		channel, err := cachedChannel(s, channelID)
 		if err != nil {
-			log.Printf("Error reading response body: %v", err)
-			privateMessageCreate(s, m.Author.ID, fmt.Sprintf("Error reading response body: %v", err), false)
+			reactionLog.Error("Cannot find channel of tracked reaction", "channel", channelID, "error", err)
+			continue
 		}
 
-		// Parse the JSON response
-		var response SnapshotResponse
-		err = json.Unmarshal(body, &response)
+		users, err := allMessageReactions(s, channelID, messageID, m["emoji"].(string))
 		if err != nil {
-			log.Printf("Error parsing JSON: %v", err)
-			privateMessageCreate(s, m.Author.ID, fmt.Sprintf("Error parsing JSON: %v", err), false)
+			reactionLog.Error("Cannot check reactions", "channel", channelID, "message", messageID, "error", err)
+			continue
 		}
-		privateMessageCreate(s, m.Author.ID, viper.GetString("camerasnapshoturl")+"/"+camera+"-"+response.EventID+".jpg", false)
-
-	} else {
-		privateMessageCreate(s, m.Author.ID, "Camera not found", false)
-	}
-}
-
-// custom command function to list cameras
-func cameraList(s *discordgo.Session, m *discordgo.MessageCreate, command string, content string) {
-
-	// Define the API endpoint
-	url := viper.GetString("cameraapiurl") + "/api/config"
-
-	// Create a GET request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		privateMessageCreate(s, m.Author.ID, fmt.Sprintf("Error creating request: %v", err), false)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send the GET request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending GET request: %v", err)
-		privateMessageCreate(s, m.Author.ID, fmt.Sprintf("Error sending GET request: %v", err), false)
-	}
-	defer resp.Body.Close()
-
-	// Check if the request was successful
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Request failed with status: %d", resp.StatusCode)
-		privateMessageCreate(s, m.Author.ID, fmt.Sprintf("Request failed with status: %d", resp.StatusCode), false)
-	}
-
-	// Read the response body
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		privateMessageCreate(s, m.Author.ID, fmt.Sprintf("Error reading response body: %v", err), false)
-	}
-
-	var data map[string]interface{}
-
-	// Parse the JSON data
-	err2 := json.Unmarshal([]byte(body), &data)
-	if err != nil {
-		log.Fatalf("Error parsing JSON: %v", err2)
-	}
-
-	// Extract the cameras object
-	cameras, ok := data["cameras"].(map[string]interface{})
-	if !ok {
-		log.Fatalf("Error extracting cameras data")
-	}
-
-	// Concatenate keys into a single string with newline characters
-	var result string
-	for key := range cameras {
-		result += key + "\n"
-	}
 
-	privateMessageCreate(s, m.Author.ID, "**Camera List**\n"+result, false)
-
-}
-
-// custom command function to list all commands based on user permission
-func showHelp(s *discordgo.Session, m *discordgo.MessageCreate, command string, content string) {
-
-	user, _ := s.GuildMember(viper.GetString("defaultserverid"), m.Author.ID)
-
-	var helpMessage string
-
-	commandkey := viper.GetString("commandkey")
-
-	allCommands := viper.GetStringMap("commands")
-
-	// Loop through the commands map
-	for command, info := range allCommands {
-
-		// check if user has permission to execute a command
-		var canRun bool = false
+		for _, user := range users {
+			if user.ID == s.State.User.ID {
+				continue
+			}
 
-		// Access the "roles" for each command
-		roles, ok := info.(map[string]interface{})["roles"]
-		if !ok {
-			fmt.Printf("Help information not found for command %s\n", command)
-			continue
-		}
+			member, err := cachedMember(s, channel.GuildID, user.ID)
+			if err != nil {
+				reactionLog.Warn("Cannot find member who reacted", "userid", user.ID, "error", err)
+				continue
+			}
 
-		for _, role := range roles.([]interface{}) {
-			fmt.Println(role.(string))
-			if checkUserPerms(role.(string), user, m.Author.ID) {
-				canRun = true
+			hasrole := false
+			for _, role := range member.Roles {
+				if role == roleID {
+					hasrole = true
+				}
+			}
+			if hasrole {
+				continue
 			}
-		}
 
-		if canRun {
-			// Access the "help" field for each command
-			help, ok := info.(map[string]interface{})["help"].(string)
-			if !ok {
-				fmt.Printf("Help information not found for command %s\n", command)
+			subject := permissionSubject{session: s, guildID: channel.GuildID, channelID: channelID, member: member, userID: user.ID}
+			if !isReactionAvailable(subject, m) {
 				continue
 			}
 
-			helpMessage += commandkey + " " + command + strings.Repeat(" ", 30-len(command)) + "- " + help + "\n"
-			fmt.Printf("Command: %s\nHelp: %s\n\n", command, help)
+			if err := s.GuildMemberRoleAdd(channel.GuildID, user.ID, roleID); err != nil {
+				reactionLog.Error("Cannot add role", "userid", user.ID, "role", roleID, "error", err)
+				continue
+			}
+			reactionLog.Info("Role added for reaction made while offline", "userid", user.ID, "role", roleID)
 		}
-
 	}
-
-	// sort commands into alphabetical order
-
-	// Split the string into lines
-	lines := strings.Split(helpMessage, "\n")
-
-	// Sort the lines alphabetically
-	sort.Strings(lines)
-
-	// Join the sorted lines back together
-	helpMessage = strings.Join(lines, "\n")
-
-	helpMessage = "Help Commands:\n--------------\n" + helpMessage
-
-	privateMessageCreate(s, m.Author.ID, helpMessage, true)
 }
 
-// custom command function to call the Home Assistant API
-func apiHomeAssistant(s *discordgo.Session, m *discordgo.MessageCreate, command string, content string) {
-
-	channelID := m.Message.ChannelID
-
-	if viper.IsSet("commands." + command + ".channels." + channelID) {
-		parameters := viper.GetStringMap("commands." + command)["channels"].(map[string]interface{})[channelID].(map[string]interface{})["parameters"]
+// returns every user who reacted to a message with an emoji, discord returns at most 100 at a time
+func allMessageReactions(s *discordgo.Session, channelID string, messageID string, emoji string) ([]*discordgo.User, error) {
+	var users []*discordgo.User
+	after := ""
 
-		parameterList := parameters.([]interface{})
-
-		for _, param := range parameterList {
-			makeHomeAssistantAPIRequest(param.(string))
+	for {
+		page, err := s.MessageReactions(channelID, messageID, emoji, 100, "", after)
+		if err != nil {
+			return users, err
 		}
 
+		users = append(users, page...)
+		if len(page) < 100 {
+			return users, nil
+		}
+		after = page[len(page)-1].ID
 	}
 }
 
-// make Home Assistant API request
-func makeHomeAssistantAPIRequest(param string) {
-	url := viper.GetString("homeassistanturl")
-
-	// Check if the url ends with "/"
-	if !strings.HasSuffix(url, "/") {
-		url += "/"
-	}
-
-	// Check if the param starts with "/" and remove
-	param = strings.TrimPrefix(param, "/")
-
-	token := viper.GetString("homeassistanttoken")
-
-	// JSON payload for calling the script (if needed)
-	payload := []byte(`{}`)
-
-	// Create a new HTTP request
-	req, err := http.NewRequest("POST", url+param, ioutil.NopCloser(bytes.NewBuffer(payload)))
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return
-	}
-
-	// Set the required headers
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// make a query to a url, the request is aborted when the context ends
+func downloadApi(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		fmt.Println("Error sending request:", err)
-		return
+		integrationLog.Error("Could not create request for api", "url", url, "error", err)
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	// Read and print the response body
-	body, err := ioutil.ReadAll(resp.Body)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		fmt.Println("Error reading response:", err)
-		return
-	}
-
-	fmt.Println("Response:", string(body))
-
-}
-
-// make a query to a url
-func downloadApi(url string) string {
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("Error: Could not connect to api url:\"%s\" with error:%s", url, err)
-		return "error"
+		integrationLog.Error("Could not connect to api", "url", url, "error", err)
+		return "", err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode == http.StatusOK {
 		body, err := ioutil.ReadAll(resp.Body)
 
 		if err != nil {
-			log.Printf("Error: Cannot take snapshot URL \"%s\", Message:%s\n", url, err)
-			return "Could not take snapshot"
+			integrationLog.Error("Cannot read api response", "url", url, "error", err)
+			return "", err
 		}
 
-		return string(body)
+		return string(body), nil
 	} else {
-		log.Println("Error: Could not take snapshot " + url + " HTTPStatus: " + string(resp.StatusCode))
-		return "Could not take snapshot"
+		integrationLog.Error("Could not query api", "url", url, "status", resp.StatusCode)
+		return "", fmt.Errorf("api returned HTTPStatus: %d", resp.StatusCode)
 	}
 }
 
@@ -781,7 +793,7 @@ func takeSnapshot(camera string) string {
 	url := viper.GetString("cameraserver") + "/snap?camera=" + camera
 	resp, err := http.Get(url)
 	if err != nil {
-		log.Printf("Error: Cannot execute Get snapshot for \"%s\", Message:%s\n", camera, err)
+		integrationLog.Error("Cannot get snapshot", "camera", camera, "error", err)
 		return "Could not take snapshot"
 	}
 	defer resp.Body.Close()
@@ -790,13 +802,13 @@ func takeSnapshot(camera string) string {
 		body, err := ioutil.ReadAll(resp.Body)
 
 		if err != nil {
-			log.Printf("Error: Cannot take snapshot URL \"%s\", Message:%s\n", url, err)
+			integrationLog.Error("Cannot read snapshot", "url", url, "error", err)
 			return "Could not take snapshot"
 		}
 
 		return string(body)
 	} else {
-		log.Println("Error: Could not take snapshot " + url + " HTTPStatus: " + string(resp.StatusCode))
+		integrationLog.Error("Could not take snapshot", "url", url, "status", resp.StatusCode)
 		return "Could not take snapshot"
 	}
 }
@@ -812,8 +824,8 @@ func foundCamera(camera string) bool {
 }
 
 // check if a user has a particular role, if they have a role return true
-func checkUserPerms(role string, user *discordgo.Member, userid string) bool {
-	roledetails := strings.Split(strings.ToLower(role), ":")
+func checkUserPerms(guildID string, role string, user *discordgo.Member, userid string) bool {
+	roledetails := strings.SplitN(strings.ToLower(role), ":", 2)
 
 	if roledetails[0] == "no role set" {
 		// no role set, permission denied
@@ -825,29 +837,24 @@ func checkUserPerms(role string, user *discordgo.Member, userid string) bool {
 		return true
 	}
 
-	if roledetails[0] == "discord" {
-		// check if users allowed via discord roles
-
-		if user != nil {
-			usersDiscordRoles := user.Roles
-
-			for _, v := range usersDiscordRoles {
-				if v == strconv.Itoa(viper.GetStringMap("discordroles")[roledetails[1]].(int)) {
-					// found users discord role
-					return true
-				}
-			}
-		}
+	if roledetails[0] == "discord-name" && len(roledetails) == 2 {
+		// check if users allowed via a discord role found by its name
+		roleID, ok := roleIDByName(guildID, roledetails[1])
+		return ok && memberHasRole(user, roleID)
+	}
 
-		// user does not have needed discord role
-		return false
+	if roledetails[0] == "discord" && len(roledetails) == 2 {
+		// check if users allowed via discord roles
+		roleID, ok := discordRoleID(guildID, roledetails[1])
+		return ok && memberHasRole(user, roleID)
 
 	} else {
 		// check normal roles
 
 		result := viper.GetStringMap("commandroles")
 
-		if sliceContainsInt(result[role].([]interface{}), userid) {
+		users, _ := result[strings.ToLower(role)].([]interface{})
+		if sliceContainsInt(users, userid) {
 			// user has a role
 			return true
 		}
@@ -856,33 +863,38 @@ func checkUserPerms(role string, user *discordgo.Member, userid string) bool {
 	return false
 }
 
-// list normal roles and the users
+// logs the normal roles and their users
 func listRoles() {
 	for k, v := range viper.GetStringMap("commandroles") {
-		fmt.Printf("Role:%s\n", k)
-		for _, user := range v.([]interface{}) {
-			fmt.Println(" - ", user)
-		}
+		logger.Debug("Role", "role", k, "users", v)
 	}
 }
 
 // checks if a role is valid
-func isRoleValid(role string) bool {
+func isRoleValid(guildID string, role string) bool {
 
 	if strings.ToLower(role) == "all" {
 		return true
 	}
 
-	roledetails := strings.Split(strings.ToLower(role), ":")
+	roledetails := strings.SplitN(strings.ToLower(role), ":", 2)
+
+	// check if it is a discord role given by name, these are resolved when the bot starts and when roles change
+	if roledetails[0] == "discord-name" && len(roledetails) == 2 {
+		_, ok := roleIDByName(guildID, roledetails[1])
+		return ok
+	}
 
 	// check if it is a discord role
-	if roledetails[0] == "discord" {
-		if !viper.IsSet("discordroles") {
-			log.Printf("Error: discordroles not configured")
+	if roledetails[0] == "discord" && len(roledetails) == 2 {
+		discordroles := guildDiscordRoles(guildID)
+
+		if len(discordroles) == 0 {
+			logger.Error("discordroles not configured")
 			return false
 		}
 
-		if _, ok := viper.GetStringMap("discordroles")[roledetails[1]]; ok {
+		if _, ok := discordroles[roledetails[1]]; ok {
 			// found valid discord role
 			return true
 		}
@@ -924,7 +936,7 @@ func privateMessageCreate(s *discordgo.Session, userid string, message string, c
 	// create the private message channel to user
 	channel, err := s.UserChannelCreate(userid)
 	if err != nil {
-		log.Printf("Error: Creating PM channel to %s with %s\n", userid, err)
+		logger.Error("Cannot create PM channel", "userid", userid, "error", err)
 		s.ChannelMessageSend(userid, "Something went wrong while sending the DM!")
 		return
 	}
@@ -949,44 +961,54 @@ func privateMessageCreate(s *discordgo.Session, userid string, message string, c
 		// send the message to the user
 		_, err = s.ChannelMessageSend(channel.ID, wrapper+message+wrapper)
 		if err != nil {
-			log.Printf("Error: Cannot send DM to %s with %s\n", userid, err)
+			logger.Error("Cannot send DM", "userid", userid, "error", err)
 			s.ChannelMessageSend(userid, "Failed to send you a DM. Did you disable DM in your privacy settings?")
 		}
 	}
 
 }
 
-// send a message to a channel
+// send a message to the channel a message came from
 func channelMessageCreate(s *discordgo.Session, m *discordgo.MessageCreate, message string, codeblock bool) {
+	if err := channelIDMessageCreate(s, m.ChannelID, message, codeblock); err != nil {
+		logger.Error("Cannot send message to channel", "channel", m.ChannelID, "error", err)
+	}
+}
+
+// send a message to a channel, chunked if it is too long for one message
+func channelIDMessageCreate(s *discordgo.Session, channelID string, message string, codeblock bool) error {
 	var wrapper string
 	if codeblock {
 		wrapper = "```"
 	}
 
-	var err error
-
-	if len(message) > viper.GetInt("chunksize") {
-		messagechunks := chunkMessage(message, viper.GetString("splitchar"), viper.GetInt("chunksize"))
-		var allkeys []int
-		for k, _ := range messagechunks {
-			allkeys = append(allkeys, k)
+	for _, chunk := range messageChunks(message) {
+		if _, err := s.ChannelMessageSend(channelID, wrapper+chunk+wrapper); err != nil {
+			return err
 		}
-		sort.Ints(allkeys[:])
+	}
 
-		for _, key := range allkeys {
-			_, err = s.ChannelMessageSend(m.ChannelID, wrapper+messagechunks[key]+wrapper)
-			// todo: handle error
-		}
+	return nil
+}
 
-	} else {
+// splits a message into chunks of at most chunksize, in order
+func messageChunks(message string) []string {
+	if len(message) <= viper.GetInt("chunksize") {
+		return []string{message}
+	}
 
-		// send the message to the user
-		_, err = s.ChannelMessageSend(m.ChannelID, wrapper+message+wrapper)
-		if err != nil {
-			log.Printf("Error: Cannot send message to channel: %s\n", err)
-		}
+	messagechunks := chunkMessage(message, viper.GetString("splitchar"), viper.GetInt("chunksize"))
+	var allkeys []int
+	for k := range messagechunks {
+		allkeys = append(allkeys, k)
 	}
+	sort.Ints(allkeys)
 
+	var chunks []string
+	for _, key := range allkeys {
+		chunks = append(chunks, messagechunks[key])
+	}
+	return chunks
 }
 
 // reads a file
@@ -1001,80 +1023,85 @@ func loadFile(filename string) (string, error) {
 	return string(filecontents), err
 }
 
-func findCommand(thecommand string) (string, bool, map[string]string) {
-
-	isValidCommand := false
-
-	allparts := strings.Split(thecommand, " ")
-	num_allparts := len(allparts)
-
-	var checkthiscommand string = ""
-
-	var lastvalidcommandfound string = ""
-
-	var option_num int = 0
+// runs a shell command using the shell profile of a command and gathers output, the command and any children are killed when the context ends
+func shellOut(ctx context.Context, cmdkey string, command string) shellResult {
+	cmd, profile, err := shellCommand(cmdkey, command)
+	if err != nil {
+		return shellResult{ExitCode: -1, Err: err}
+	}
 
-	options := make(map[string]string)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	limitedStdout := newLimitedWriter(&stdout, profile.output)
+	limitedStderr := newLimitedWriter(&stderr, profile.output)
+	cmd.Stdout = limitedStdout
+	cmd.Stderr = limitedStderr
+
+	started := time.Now()
+	err = runCommand(ctx, cmd)
+
+	result := shellResult{
+		ExitCode:  exitCode(err),
+		Duration:  time.Since(started),
+		Truncated: limitedStdout.truncated || limitedStderr.truncated,
+	}
 
-	for i := 0; i < num_allparts; i++ {
-		if i == 0 {
-			checkthiscommand = allparts[0]
-		} else {
-			checkthiscommand = checkthiscommand + " " + allparts[i]
-		}
+	// exiting with a non zero code is reported through the exit code, not as an error
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		result.Err = err
+	}
 
-		if _, ok := viper.GetStringMap("commands")[checkthiscommand]; ok {
-			lastvalidcommandfound = checkthiscommand
-			isValidCommand = true
+	if limitedStdout.truncated {
+		stdout.WriteString("\n(output truncated)")
+	}
+	if limitedStderr.truncated {
+		stderr.WriteString("\n(output truncated)")
+	}
 
-			// assume all remaining unparse tokens are optional.  each loop will update the list until no further valid commands are found
-			option_num = 0
-			new_options := make(map[string]string)
-			for oi := i + 1; oi < num_allparts; oi++ {
-				new_options["{"+strconv.Itoa(option_num)+"}"] = allparts[oi]
-				option_num++
-			}
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
 
-			options = new_options
+	return result
+}
 
-		} else {
-			// command not matched, continue iterating through commands looking for the longest matching combination
-		}
+// runs a command in its own process group, killing the whole group when the context ends
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
 
+	if err := cmd.Start(); err != nil {
+		return err
 	}
 
-	return lastvalidcommandfound, isValidCommand, options
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		return ctx.Err()
+	}
 }
 
-func canaryCheckin(url string, interval int) {
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
-	for _ = range ticker.C {
-		client := http.Client{
-			Timeout: 5 * time.Second,
-		}
-		resp, err := client.Get(url)
-		if err != nil {
-			log.Printf("Error: Could not connect to canary with error:%s", err)
-		} else {
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				log.Printf("Error: Could not checkin to canary with error:%s", err)
-			} else {
-				log.Println("Success: Canary Checkin")
-			}
-		}
+// reads a duration from the config, plain numbers are seconds otherwise durations like 1m30s are used
+func getDuration(key string) time.Duration {
+	value := viper.GetString(key)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
 	}
-}
 
-// runs a shell command and gathers output
-func shellOut(command string) (error, string, string) {
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd := exec.Command(viper.GetString("shell"), "-c", command)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	return err, stdout.String(), stderr.String()
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Error("Invalid duration", "setting", key, "duration", value)
+		return 0
+	}
+
+	return duration
 }
 
 // splits (chunks) a message
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// actions a step can run, a step runs exactly one of them
+var stepActions = []string{"api", "shell", "homeassistant", "function", "message", "wait"}
+
+// the outcome of a step, later steps can template on it using {name.output} etc
+type stepResult struct {
+	Name     string
+	Action   string
+	Status   string // success, failure or skipped
+	Output   string
+	ExitCode int
+	Duration time.Duration
+	// later steps still run when this step fails
+	continues bool
+}
+
+// one step of a workflow as configured under steps
+type workflowStep map[string]interface{}
+
+// returns a setting of a step as a string
+func (step workflowStep) get(key string) (string, bool) {
+	value, ok := step[key]
+	if !ok || value == nil {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+// returns the action a step runs
+func (step workflowStep) action() string {
+	for _, action := range stepActions {
+		if _, ok := step[action]; ok {
+			return action
+		}
+	}
+	return ""
+}
+
+// returns the steps configured for a command
+func commandSteps(cmdkey string) []workflowStep {
+	var steps []workflowStep
+
+	list, ok := viper.Get(cmdkey + ".steps").([]interface{})
+	if !ok {
+		return steps
+	}
+
+	for i, item := range list {
+		step, ok := item.(map[string]interface{})
+		if !ok {
+			commandLog.Error("Step is not a map", "step", i+1, "key", cmdkey)
+			continue
+		}
+
+		// viper lowercases keys in maps, but not those nested in lists
+		lowered := make(workflowStep)
+		for key, value := range step {
+			lowered[strings.ToLower(key)] = value
+		}
+		steps = append(steps, lowered)
+	}
+
+	return steps
+}
+
+// returns every template used by the steps of a command, so required arguments can be worked out
+func stepTemplates(cmdkey string) []string {
+	var templates []string
+	for _, step := range commandSteps(cmdkey) {
+		for _, key := range append(stepActions, "args") {
+			if value, ok := step.get(key); ok {
+				templates = append(templates, value)
+			}
+		}
+	}
+	return templates
+}
+
+// options used when templating with the result of a step
+func (r stepResult) options(prefix string) map[string]string {
+	return map[string]string{
+		"{" + prefix + ".output}":   strings.TrimRight(r.Output, "\n"),
+		"{" + prefix + ".status}":   r.Status,
+		"{" + prefix + ".exitcode}": strconv.Itoa(r.ExitCode),
+		"{" + prefix + ".duration}": r.Duration.Round(10 * time.Millisecond).String(),
+	}
+}
+
+// checks the when condition of a step against earlier results, conditions are on the previous step unless a step is named
+func stepConditionMet(step workflowStep, results []stepResult) bool {
+	when, ok := step["when"].(map[string]interface{})
+	if !ok {
+		// steps without a condition only run while the workflow is succeeding
+		for _, result := range results {
+			if result.Status == "failure" && !result.continues {
+				return false
+			}
+		}
+		return true
+	}
+
+	if len(results) == 0 {
+		return false
+	}
+
+	result := results[len(results)-1]
+	if name, ok := when["step"]; ok {
+		found := false
+		for _, earlier := range results {
+			if earlier.Name == fmt.Sprint(name) {
+				result = earlier
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if status, ok := when["status"]; ok && fmt.Sprint(status) != result.Status {
+		return false
+	}
+
+	if exitcode, ok := when["exitcode"]; ok && fmt.Sprint(exitcode) != strconv.Itoa(result.ExitCode) {
+		return false
+	}
+
+	return true
+}
+
+// parses the wait of a step, a number of seconds or a duration like 10s
+func stepWait(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// quotes a value so sh -c treats it as a single word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// runs one step, returning its output. the results of earlier steps come from apis and commands, so shell steps get
+// them quoted rather than letting them add commands of their own
+func runStep(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, request commandRequest, step workflowStep, options map[string]string, stepoptions map[string]string, result *stepResult) error {
+	value, _ := step.get(result.Action)
+
+	if result.Action == "shell" {
+		quoted := make(map[string]string, len(stepoptions))
+		for key, option := range stepoptions {
+			quoted[key] = shellQuote(option)
+		}
+		value = templateReplacer(options, quoted).Replace(value)
+	} else {
+		value = templateReplacer(options, stepoptions).Replace(value)
+	}
+
+	switch result.Action {
+	case "api":
+		output, err := downloadApi(ctx, value)
+		result.Output = output
+		return err
+
+	case "shell":
+		if !viper.GetBool("shellenable") {
+			return errors.New("cannot run shell command when shellenable = false")
+		}
+		shell := shellOut(ctx, request.Key, value)
+		if shell.Err != nil {
+			return shell.Err
+		}
+		if viper.GetBool(request.Key+".hidestderr") || shell.Stderr == "" {
+			result.Output = shell.Stdout
+		} else {
+			result.Output = shell.Stdout + "\nSTDERR:\n-------\n" + shell.Stderr
+		}
+		result.ExitCode = shell.ExitCode
+		if shell.ExitCode != 0 {
+			return fmt.Errorf("exit code %d", shell.ExitCode)
+		}
+		return nil
+
+	case "homeassistant":
+		return makeHomeAssistantAPIRequest(ctx, value)
+
+	case "function":
+		function, ok := functions[value]
+		if !ok {
+			return fmt.Errorf("function %s not found", value)
+		}
+
+		// functions get the templated args of the step as if they were typed after the command
+		functionRequest := functionRequest{Session: s, Message: m, commandRequest: request}
+		if args, ok := step.get("args"); ok {
+			functionRequest.Rest = templateReplacer(options, stepoptions).Replace(args)
+			functionRequest.Args = strings.Fields(functionRequest.Rest)
+		}
+		return function.Execute(ctx, functionRequest)
+
+	case "message":
+		result.Output = value
+		if viper.GetBool(request.Key + ".secret") {
+			privateMessageCreate(s, m.Author.ID, value, false)
+		} else {
+			channelMessageCreate(s, m, value, false)
+		}
+		return nil
+
+	case "wait":
+		wait, err := stepWait(value)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+			return nil
+		}
+	}
+
+	return errors.New("step has no action, use one of " + strings.Join(stepActions, ", "))
+}
+
+// runs the steps of a command one after another then sends a summary, returns the status for the audit log
+func runSteps(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, request commandRequest, replyoptions map[string]string) string {
+	cmdkey := request.Key
+	steps := commandSteps(cmdkey)
+
+	options := request.options()
+	// results of the steps run so far, kept apart from the options the user gave
+	stepoptions := make(map[string]string)
+	var results []stepResult
+	status := "success"
+
+	for i, step := range steps {
+		result := stepResult{Name: "step" + strconv.Itoa(i+1), Action: step.action(), Status: "skipped"}
+		if name, ok := step.get("name"); ok {
+			result.Name = name
+		}
+		result.continues = fmt.Sprint(step["continue"]) == "true"
+
+		if stepConditionMet(step, results) {
+			started := time.Now()
+			err := runStep(ctx, s, m, request, step, options, stepoptions, &result)
+			result.Duration = time.Since(started)
+
+			if err != nil {
+				commandLog.Error("Step failed", "step", result.Name, "command", request.Command, "error", err)
+				result.Status = "failure"
+				if result.Output == "" {
+					result.Output = err.Error()
+				}
+				if !result.continues {
+					status = "failed at " + result.Name
+				}
+			} else {
+				result.Status = "success"
+			}
+		}
+
+		// the workflow ran out of time, so no later step can run
+		if ctx.Err() != nil {
+			return sendFailureReply(ctx, s, m, request.GuildID, cmdkey, replyoptions)
+		}
+
+		results = append(results, result)
+		for key, value := range result.options(result.Name) {
+			stepoptions[key] = value
+		}
+		for key, value := range result.options("previous") {
+			stepoptions[key] = value
+		}
+	}
+
+	stepoptions["{status}"] = status
+
+	// an empty summary sends nothing
+	summary := viper.GetString(cmdkey + ".summary")
+	if !viper.IsSet(cmdkey + ".summary") {
+		var lines []string
+		for _, result := range results {
+			line := fmt.Sprintf("%s: %s", result.Name, result.Status)
+			if result.Status != "skipped" {
+				line += fmt.Sprintf(" (%s)", result.Duration.Round(10*time.Millisecond))
+			}
+			lines = append(lines, line)
+		}
+		summary = strings.Join(lines, "\n")
+	}
+
+	if summary = templateReplacer(options, stepoptions).Replace(summary); summary != "" {
+		if viper.GetBool(cmdkey + ".secret") {
+			privateMessageCreate(s, m.Author.ID, summary, false)
+		} else {
+			channelMessageCreate(s, m, summary, false)
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+// a buffer that can be written by a command while being read by the bot
+type streamBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *streamBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *streamBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+// runs a shell command posting a message that is edited with the latest output as it runs, returns the exit code.
+// hidestderr keeps stderr out of the message, and a success or failure template replaces the output once it finishes
+func streamShell(ctx context.Context, s *discordgo.Session, channelID string, cmdkey string, command string, commandoptions map[string]string) (int, error) {
+	cmd, profile, err := shellCommand(cmdkey, command)
+	if err != nil {
+		return -1, err
+	}
+
+	interval := 2 * time.Second
+	if viper.IsSet("streaminterval") {
+		interval = getDuration("streaminterval")
+	}
+
+	// discord rate limits message edits, so never edit more than once a second
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	message, err := s.ChannelMessageSend(channelID, "```\nRunning...\n```")
+	if err != nil {
+		commandLog.Error("Cannot send streaming message", "channel", channelID, "error", err)
+		return -1, err
+	}
+
+	var output, stdout, stderr streamBuffer
+	limitedOutput := newLimitedWriter(&output, profile.output)
+	limitedStdout := newLimitedWriter(&stdout, profile.output)
+	limitedStderr := newLimitedWriter(&stderr, profile.output)
+
+	// stdout and stderr are kept apart as well for the success and failure templates
+	cmd.Stdout = io.MultiWriter(limitedOutput, limitedStdout)
+	cmd.Stderr = io.MultiWriter(limitedOutput, limitedStderr)
+	if viper.GetBool(cmdkey + ".hidestderr") {
+		cmd.Stderr = limitedStderr
+	}
+
+	started := time.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runCommand(ctx, cmd)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastsent := ""
+	for {
+		select {
+		case err = <-done:
+			exitcode := exitCode(err)
+
+			footer := fmt.Sprintf("Exit code: %d, runtime: %s", exitcode, time.Since(started).Round(100*time.Millisecond))
+			if limitedOutput.truncated {
+				footer += " (output truncated)"
+			}
+			if ctx.Err() != nil {
+				footer = fmt.Sprintf("Stopped after %s", time.Since(started).Round(100*time.Millisecond))
+			}
+
+			content := streamContent(output.String(), footer)
+
+			result := shellResult{
+				ExitCode:  exitcode,
+				Stdout:    stdout.String(),
+				Stderr:    stderr.String(),
+				Duration:  time.Since(started),
+				Truncated: limitedStdout.truncated || limitedStderr.truncated,
+			}
+			if ctx.Err() == nil {
+				// the message can only be edited, so a long response is cut to its first chunk
+				if response, codeblock := shellResponse(cmdkey, result, commandoptions); !codeblock {
+					content = messageChunks(response)[0]
+				}
+			}
+
+			s.ChannelMessageEdit(channelID, message.ID, content)
+
+			// exit codes are reported in the message, only errors running the command are returned
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return exitcode, nil
+			}
+			return exitcode, err
+
+		case <-ticker.C:
+			current := output.String()
+			if current == lastsent {
+				continue
+			}
+			lastsent = current
+
+			if _, err := s.ChannelMessageEdit(channelID, message.ID, streamContent(current, "Running for "+time.Since(started).Round(time.Second).String())); err != nil {
+				commandLog.Error("Cannot edit streaming message", "channel", channelID, "error", err)
+			}
+		}
+	}
+}
+
+// the least output shown in a streaming message
+const minStreamOutput = 100
+
+// builds a streaming message from the end of the output so it fits within the chunk size
+func streamContent(output string, footer string) string {
+	// leave room for the code block and footer, always showing some output when the chunk size is too small for them
+	maxlength := viper.GetInt("chunksize") - len(footer) - 20
+	if maxlength < minStreamOutput {
+		maxlength = minStreamOutput
+	}
+
+	if len(output) > maxlength {
+		output = "...\n" + strings.ToValidUTF8(output[len(output)-maxlength+4:], "")
+	}
+
+	if output == "" {
+		output = "(no output)\n"
+	}
+
+	return "```\n" + output + "\n```" + footer
+}
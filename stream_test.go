@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestStreamContent(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunksize int
+		output    string
+		footer    string
+		contains  string
+		maxlength int
+	}{
+		{"short output", 1980, "hello", "", "```\nhello\n```", 1980},
+		{"no output", 1980, "", "", "(no output)", 1980},
+		{"long output keeps the end", 200, strings.Repeat("a", 500) + "end", "", "end", 200},
+		{"footer", 200, "hello", "\nfinished", "```\nhello\n```\nfinished", 200},
+		{"footer longer than the chunk size", 50, strings.Repeat("a", 500), strings.Repeat("f", 100), "...", 50 + 100 + minStreamOutput},
+		{"tiny chunk size", 1, strings.Repeat("a", 500), "", "...", minStreamOutput + 20},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useConfig(t, "")
+			viper.Set("chunksize", test.chunksize)
+
+			content := streamContent(test.output, test.footer)
+			if !strings.Contains(content, test.contains) {
+				t.Errorf("streamContent() = %q, want it to contain %q", content, test.contains)
+			}
+			if len(content) > test.maxlength {
+				t.Errorf("streamContent() is %d long, want at most %d", len(content), test.maxlength)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// a possible command the user meant to type
+type suggestion struct {
+	name     string
+	command  string
+	distance int
+	// how many words of the attempt the name covers, names covering more are better matches
+	covered int
+	// the attempt was only the start of the name
+	prefix bool
+}
+
+// returns the closest commands and aliases to the attempted command that a user is allowed to run
+func suggestCommands(subject permissionSubject, attempted string) []string {
+	guildID := subject.guildID
+
+	// commands are found whatever their case
+	words := strings.Fields(strings.ToLower(attempted))
+	if len(words) == 0 {
+		return nil
+	}
+
+	limit := 3
+	if viper.IsSet("suggestionlimit") {
+		limit = viper.GetInt("suggestionlimit")
+	}
+
+	// keep the best match for each command, so a command and its aliases are only suggested once
+	best := make(map[string]suggestion)
+
+	for name, command := range commandNames(guildID) {
+		// compare against the same number of words as the name, the rest of the attempt are options
+		namewords := strings.Fields(strings.ToLower(name))
+		if len(namewords) > len(words) {
+			namewords = namewords[:len(words)]
+		}
+		candidate := strings.Join(words[:len(namewords)], " ")
+		compared := strings.Join(namewords, " ")
+
+		distance := levenshtein(candidate, compared)
+
+		// a prefix of the name counts as a close match
+		prefix := false
+		if distance > 0 && strings.HasPrefix(strings.ToLower(name), candidate) {
+			distance = 0
+			prefix = true
+		}
+
+		// the limit comes from the words compared, not the whole name, so short attempts only match short words
+		if distance > maxSuggestionDistance(compared) {
+			continue
+		}
+
+		match := suggestion{name: name, command: command, distance: distance, covered: len(namewords), prefix: prefix}
+		if current, ok := best[command]; ok && !betterSuggestion(match, current) {
+			continue
+		}
+
+		best[command] = match
+	}
+
+	var matches []suggestion
+	for command, match := range best {
+		if canRunCommand(subject, commandKey(guildID, command)) {
+			matches = append(matches, match)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return betterSuggestion(matches[i], matches[j])
+	})
+
+	var suggestions []string
+	for i, match := range matches {
+		if i >= limit {
+			break
+		}
+		suggestions = append(suggestions, match.name)
+	}
+
+	return suggestions
+}
+
+// checks if one suggestion is a better match than another, names matching more of the attempt come first then
+// whole names before ones the attempt was only the start of, then the closest, then command names before aliases
+func betterSuggestion(a suggestion, b suggestion) bool {
+	if a.covered != b.covered {
+		return a.covered > b.covered
+	}
+	if a.prefix != b.prefix {
+		return !a.prefix
+	}
+	if a.distance != b.distance {
+		return a.distance < b.distance
+	}
+	// the command's own name reads better than one of its aliases
+	if (a.name == a.command) != (b.name == b.command) {
+		return a.name == a.command
+	}
+	return a.name < b.name
+}
+
+// the largest edit distance allowed when suggesting a name, longer names allow more typos
+func maxSuggestionDistance(name string) int {
+	distance := len(name) / 3
+	if viper.IsSet("suggestiondistance") {
+		distance = viper.GetInt("suggestiondistance")
+	} else if distance < 1 {
+		distance = 1
+	}
+
+	// replacing every letter is not a typo
+	if length := len([]rune(name)); distance >= length {
+		distance = length - 1
+	}
+
+	return distance
+}
+
+// calculates the levenshtein edit distance between two strings
+func levenshtein(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+// returns the smaller of two ints
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+const suggestConfig = `
+commandroles:
+  admin:
+    - 111
+commands:
+  wiki:
+    message: "x"
+    roles: [all]
+  "my name is":
+    message: "x"
+    roles: [all]
+  echo:
+    message: "x"
+    roles: [all]
+  camera:
+    aliases: [cam]
+    roles: [all]
+    subcommands:
+      snapshot:
+        aliases: [snap]
+        api: "x"
+      list:
+        api: "x"
+  secret:
+    message: "x"
+    roles: [admin]
+`
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"wiki", "wiki", 0},
+		{"wki", "wiki", 1},
+		{"wiki", "", 4},
+		{"kitten", "sitting", 3},
+		{"camra", "camera", 1},
+		{"héllo", "hello", 1},
+	}
+
+	for _, test := range tests {
+		if distance := levenshtein(test.a, test.b); distance != test.distance {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", test.a, test.b, distance, test.distance)
+		}
+		if distance := levenshtein(test.b, test.a); distance != test.distance {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", test.b, test.a, distance, test.distance)
+		}
+	}
+}
+
+func TestSuggestCommands(t *testing.T) {
+	tests := []struct {
+		attempted   string
+		userid      string
+		suggestions []string
+	}{
+		{"wki", "444", []string{"wiki"}},
+		{"ecko", "444", []string{"echo"}},
+		{"e", "444", []string{"echo"}},
+		{"ww", "444", nil},
+		{"camra", "444", []string{"camera", "camera list", "camera snapshot"}},
+		{"Camra", "444", []string{"camera", "camera list", "camera snapshot"}},
+		{"camera snpshot", "444", []string{"camera snapshot", "camera"}},
+		{"my nme is", "444", []string{"my name is"}},
+		{"wiki2 page", "444", []string{"wiki"}},
+		{"secrt", "444", nil},
+		{"secrt", "111", []string{"secret"}},
+		{"zzzzzzzz", "444", nil},
+		{"", "444", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.attempted+"/"+test.userid, func(t *testing.T) {
+			useConfig(t, suggestConfig)
+			suggestions := suggestCommands(permissionSubject{member: &discordgo.Member{}, userID: test.userid}, test.attempted)
+			if !reflect.DeepEqual(suggestions, test.suggestions) {
+				t.Errorf("suggestCommands(%q) = %q, want %q", test.attempted, suggestions, test.suggestions)
+			}
+		})
+	}
+}
+
+func TestMaxSuggestionDistance(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured int
+		distance   int
+	}{
+		{"ww", 0, 1},
+		{"e", 0, 0},
+		{"camera", 0, 2},
+		{"camera snapshot", 0, 5},
+		{"ww", 2, 1},
+		{"camera", 2, 2},
+	}
+
+	for _, test := range tests {
+		useConfig(t, "")
+		if test.configured > 0 {
+			viper.Set("suggestiondistance", test.configured)
+		}
+
+		if distance := maxSuggestionDistance(test.name); distance != test.distance {
+			t.Errorf("maxSuggestionDistance(%q) with %d configured = %d, want %d", test.name, test.configured, distance, test.distance)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
+)
+
+var (
+	errQueueFull    = errors.New("command queue full")
+	errShuttingDown = errors.New("shutting down")
+)
+
+// runs commands on a bounded number of workers, each users commands are queued and run one after another
+type workerPool struct {
+	mutex     sync.Mutex
+	slots     chan struct{}
+	queues    map[string][]func()
+	running   map[string]bool
+	queuesize int
+	closed    bool
+	inflight  sync.WaitGroup
+}
+
+// pool used to run all commands
+var commandWorkers = newWorkerPool(4, 5)
+
+// creates a pool with a number of workers, and a limit on how many commands each user can have waiting
+func newWorkerPool(workers int, queuesize int) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &workerPool{
+		slots:     make(chan struct{}, workers),
+		queues:    make(map[string][]func()),
+		running:   make(map[string]bool),
+		queuesize: queuesize,
+	}
+}
+
+// creates the command pool from the config
+func setupWorkers() {
+	workers := 4
+	if viper.IsSet("workers") {
+		workers = viper.GetInt("workers")
+	}
+
+	queuesize := 5
+	if viper.IsSet("userqueue") {
+		queuesize = viper.GetInt("userqueue")
+	}
+
+	commandWorkers = newWorkerPool(workers, queuesize)
+}
+
+// queues a job for a user
+func (p *workerPool) submit(userid string, job func()) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return errShuttingDown
+	}
+
+	if p.queuesize > 0 && len(p.queues[userid]) >= p.queuesize {
+		return errQueueFull
+	}
+
+	p.queues[userid] = append(p.queues[userid], job)
+	p.inflight.Add(1)
+
+	// start working through the users queue if it is not already running
+	if !p.running[userid] {
+		p.running[userid] = true
+		go p.runQueue(userid)
+	}
+
+	return nil
+}
+
+// runs the jobs queued for a user one at a time until the queue is empty
+func (p *workerPool) runQueue(userid string) {
+	for {
+		p.mutex.Lock()
+		queue := p.queues[userid]
+		if len(queue) == 0 {
+			delete(p.queues, userid)
+			delete(p.running, userid)
+			p.mutex.Unlock()
+			return
+		}
+		job := queue[0]
+		p.queues[userid] = queue[1:]
+		p.mutex.Unlock()
+
+		// wait for a free worker
+		p.slots <- struct{}{}
+		job()
+		<-p.slots
+
+		p.inflight.Done()
+	}
+}
+
+// stops accepting jobs and waits for queued and running jobs to finish, returns false if the timeout was reached first
+func (p *workerPool) shutdown(timeout time.Duration) bool {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// shows the bot typing in a channel while a command runs, if it is still running after the typing delay. returns a function to stop it
+func startTyping(s *discordgo.Session, channelID string) func() {
+	delay := 2 * time.Second
+	if viper.IsSet("typingdelay") {
+		delay = getDuration("typingdelay")
+	}
+
+	stop := make(chan struct{})
+
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		// discord shows typing for 10 seconds, so refresh it until stopped
+		ticker := time.NewTicker(8 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			s.ChannelTyping(channelID)
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+		})
+	}
+}